@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type syncStatusResponse struct {
+	Source     string     `json:"source"`
+	LastUpdate string     `json:"last_update"`
+	Runs       []*SyncRun `json:"runs"`
+}
+
+func (s *server) adminRoutes() {
+	admin := s.router.PathPrefix("/admin").Subrouter()
+	admin.Use(s.requireAdmin)
+	admin.HandleFunc("/sync", s.handleTriggerSync()).Methods("POST")
+	admin.HandleFunc("/sync/status", s.handleSyncStatus()).Methods("GET")
+	admin.HandleFunc("/keys", s.handleCreateAPIKey()).Methods("POST")
+	admin.HandleFunc("/keys", s.handleGetAPIKeys()).Methods("GET")
+	admin.HandleFunc("/keys/{id}", s.handleDeleteAPIKey()).Methods("DELETE")
+}
+
+// requireAdmin only lets requests through which provide the
+// configured admin token as a bearer token.
+func (s *server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			respond(w, http.StatusForbidden, &invalidRequestResponse{"Admin API is disabled"})
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			respond(w, http.StatusUnauthorized, &invalidRequestResponse{"Invalid admin token"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// findSyncer returns the syncer for the source requested via the
+// source query parameter. Without it, the first configured source
+// is used, which is the DWD unless configured otherwise.
+func (s *server) findSyncer(w http.ResponseWriter, r *http.Request) (*Syncer, bool) {
+	if len(s.syncers) == 0 {
+		respond(w, http.StatusServiceUnavailable, &invalidRequestResponse{"Syncing is not enabled"})
+		return nil, false
+	}
+
+	name := r.URL.Query().Get("source")
+	if name == "" {
+		return s.syncers[0], true
+	}
+
+	for _, syncer := range s.syncers {
+		if syncer.dataSource().Name() == strings.ToLower(name) {
+			return syncer, true
+		}
+	}
+
+	respond(w, http.StatusNotFound, &invalidRequestResponse{"No such source"})
+	return nil, false
+}
+
+func (s *server) handleTriggerSync() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		syncer, ok := s.findSyncer(w, r)
+		if !ok {
+			return
+		}
+
+		run := syncer.Sync(triggerAdmin)
+		if run.Error != "" {
+			respond(w, http.StatusBadGateway, run)
+			return
+		}
+
+		respond(w, http.StatusOK, run)
+	}
+}
+
+func (s *server) handleSyncStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		syncer, ok := s.findSyncer(w, r)
+		if !ok {
+			return
+		}
+
+		runs := syncer.Runs()
+
+		res := &syncStatusResponse{Source: syncer.dataSource().Name(), Runs: runs}
+		for _, run := range runs {
+			if run.Error == "" {
+				res.LastUpdate = run.LastUpdate
+				break
+			}
+		}
+
+		respond(w, http.StatusOK, res)
+	}
+}
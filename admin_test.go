@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuthentication(t *testing.T) {
+	tests := []struct {
+		description string
+		adminToken  string
+		header      string
+		want        int
+	}{
+		{
+			"admin api is disabled without a token",
+			"",
+			"Bearer ",
+			http.StatusForbidden,
+		},
+		{
+			"missing token",
+			"::token::",
+			"",
+			http.StatusUnauthorized,
+		},
+		{
+			"wrong token",
+			"::token::",
+			"Bearer ::wrong::",
+			http.StatusUnauthorized,
+		},
+		{
+			"correct token",
+			"::token::",
+			"Bearer ::token::",
+			http.StatusOK,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			s := createServer()
+			s.adminToken = tc.adminToken
+			s.syncers = []*Syncer{{}}
+
+			req := httptest.NewRequest("GET", "/admin/sync/status", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+
+			if w.Code != tc.want {
+				t.Errorf("wanted status %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestAdminTriggerSync(t *testing.T) {
+	dwd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json, _ := json.Marshal(upstreamResponse)
+		w.Write(json)
+	}))
+	defer dwd.Close()
+
+	s := createServer()
+	s.adminToken = "::token::"
+	s.syncers = []*Syncer{{
+		upstream: &httpUpstream{dwd.URL},
+		storage:  &inMemoryStorage{},
+	}}
+
+	req := httptest.NewRequest("POST", "/admin/sync", nil)
+	req.Header.Set("Authorization", "Bearer ::token::")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("wanted status 200, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/sync/status", nil)
+	req.Header.Set("Authorization", "Bearer ::token::")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	var got syncStatusResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("unable to decode response: %q", err)
+	}
+
+	if len(got.Runs) != 1 || got.Runs[0].Trigger != triggerAdmin {
+		t.Errorf("expected a single admin run, got %+v", got.Runs)
+	}
+
+	if got.LastUpdate != upstreamResponse.LastUpdate {
+		t.Errorf("wanted last update %q, got %q", upstreamResponse.LastUpdate, got.LastUpdate)
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	apiKeyParam  = "api_key"
+
+	defaultKeyRateLimit = 600
+	rateLimitPeriod     = time.Minute
+)
+
+// APIKey identifies a client of the API. The key itself is only
+// returned once when it gets created, afterwards only its hash
+// is stored.
+type APIKey struct {
+	ID   string `json:"id"`
+	Key  string `json:"key,omitempty"`
+	Name string `json:"name"`
+	// RateLimit is the number of requests per minute. If it is
+	// zero, the default limit for keys is used.
+	RateLimit int `json:"rate_limit"`
+	// AllowedOrigins restricts browser requests with this key to
+	// these origins. If it is empty, the global CORS policy applies.
+	AllowedOrigins []string  `json:"allowed_origins,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// APIKeyStorage defines a type that can save and retrieve
+// API keys.
+type APIKeyStorage interface {
+	SaveAPIKey(k *APIKey, hash string) error
+	GetAPIKey(hash string) (*APIKey, error)
+	DeleteAPIKey(id string) error
+	AllAPIKeys() ([]*APIKey, error)
+}
+
+// accessConfig controls who can access the API and how often.
+type accessConfig struct {
+	// requireKey rejects requests without an API key.
+	requireKey bool
+	// anonymousLimit and keyLimit are the number of requests per
+	// minute per IP and per key. Zero disables the limit.
+	anonymousLimit int
+	keyLimit       int
+	// trustedProxies are the reverse proxies whose X-Forwarded-For
+	// header is used to determine the client IP.
+	trustedProxies []*net.IPNet
+}
+
+// keyLookup holds the result of looking up the API key of a
+// request, so the key only gets loaded once per request.
+type keyLookup struct {
+	once sync.Once
+	key  *APIKey
+	err  error
+}
+
+type contextKey int
+
+const keyLookupContextKey contextKey = iota
+
+type createAPIKeyRequest struct {
+	Name           string   `json:"name"`
+	RateLimit      int      `json:"rate_limit"`
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// newEnvAccessConfig returns an access config configured via
+// environment variables. Anonymous requests are only limited if
+// a limit is configured explicitly.
+func newEnvAccessConfig() (*accessConfig, error) {
+	c := &accessConfig{keyLimit: defaultKeyRateLimit}
+
+	c.requireKey, _ = strconv.ParseBool(os.Getenv("API_KEYS_REQUIRED"))
+
+	proxies, err := parseNetworks(splitList(os.Getenv("TRUSTED_PROXIES")))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid TRUSTED_PROXIES")
+	}
+	c.trustedProxies = proxies
+
+	limits := []struct {
+		name  string
+		value *int
+	}{
+		{"RATE_LIMIT_ANONYMOUS", &c.anonymousLimit},
+		{"RATE_LIMIT_KEY", &c.keyLimit},
+	}
+	for _, l := range limits {
+		v, exists := os.LookupEnv(l.name)
+		if !exists {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid %s %q", l.name, v)
+		}
+		*l.value = n
+	}
+
+	if c.anonymousLimit > 0 && len(c.trustedProxies) == 0 {
+		log.Printf("[auth] no TRUSTED_PROXIES configured, behind a reverse proxy all anonymous clients share a single rate limit")
+	}
+
+	return c, nil
+}
+
+// parseNetworks parses a list of IP addresses and CIDR ranges.
+// Single addresses become a network containing only themselves.
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range list {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, errors.Errorf("invalid address %q", v)
+			}
+			bits := 8 * len(ip)
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// hashAPIKey returns the hash under which a key gets stored.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestAPIKey returns the key provided in the X-API-Key header
+// or the api_key query parameter.
+func requestAPIKey(r *http.Request) string {
+	if k := r.Header.Get(apiKeyHeader); k != "" {
+		return k
+	}
+	return r.URL.Query().Get(apiKeyParam)
+}
+
+// withKeyLookup returns a copy of the request which remembers
+// the API key once it has been looked up.
+func withKeyLookup(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(keyLookupContextKey).(*keyLookup); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), keyLookupContextKey, &keyLookup{}))
+}
+
+// requestKey loads the API key with the provided value. The
+// result is reused for the rest of the request if the request
+// was prepared with withKeyLookup.
+func (s *server) requestKey(r *http.Request, key string) (*APIKey, error) {
+	l, ok := r.Context().Value(keyLookupContextKey).(*keyLookup)
+	if !ok {
+		return s.apiKeys.GetAPIKey(hashAPIKey(key))
+	}
+
+	l.once.Do(func() {
+		l.key, l.err = s.apiKeys.GetAPIKey(hashAPIKey(key))
+	})
+	return l.key, l.err
+}
+
+// clientIP returns the IP address of the client. Requests from
+// trusted proxies are attributed to the rightmost address in the
+// X-Forwarded-For header which isn't a trusted proxy itself. Any
+// address left of it was provided by the client and can be made up.
+func (c *accessConfig) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && c.trustedProxy(ip); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+	}
+
+	return ip
+}
+
+func (c *accessConfig) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, n := range c.trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupAPIKey returns the API key used by the request, or nil
+// if there is none. It writes an error response and returns
+// false if the request provided an invalid key.
+func (s *server) lookupAPIKey(w http.ResponseWriter, r *http.Request) (*APIKey, bool) {
+	key := requestAPIKey(r)
+	if key == "" {
+		if s.access != nil && s.access.requireKey {
+			respond(w, http.StatusUnauthorized, &invalidRequestResponse{"An API key is required"})
+			return nil, false
+		}
+		return nil, true
+	}
+
+	if s.apiKeys == nil {
+		return nil, true
+	}
+
+	k, err := s.requestKey(r, key)
+	if err != nil {
+		if err == ErrNotFound {
+			respond(w, http.StatusUnauthorized, &invalidRequestResponse{"Invalid API key"})
+			return nil, false
+		}
+
+		// Don't lock everybody out if the storage is having issues.
+		log.Printf("[auth] unable to look up api key: %q", err.Error())
+		return nil, true
+	}
+
+	return k, true
+}
+
+// limitAccess authenticates API keys and enforces the rate
+// limits. The admin API and the health check are exempt.
+func (s *server) limitAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" || strings.HasPrefix(r.URL.Path, "/admin/") || s.access == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, ok := s.lookupAPIKey(w, r)
+		if !ok {
+			return
+		}
+
+		bucket, limit := "ip:"+s.access.clientIP(r), s.access.anonymousLimit
+		if key != nil {
+			bucket, limit = "key:"+key.ID, s.access.keyLimit
+			if key.RateLimit > 0 {
+				limit = key.RateLimit
+			}
+		}
+
+		if limit == 0 || s.limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// If the limit can't be checked, requests are let through
+		// rather than taking down the whole API with the storage.
+		res, err := s.limiter.Take(bucket, limit, rateLimitPeriod)
+		if err != nil {
+			log.Printf("[auth] unable to check rate limit: %q", err.Error())
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+
+		if !res.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(res.RetryAfter)))
+			respond(w, http.StatusTooManyRequests, &invalidRequestResponse{"Rate limit exceeded"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func (s *server) handleCreateAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.apiKeys == nil {
+			respond(w, http.StatusServiceUnavailable, &invalidRequestResponse{"API keys are not supported"})
+			return
+		}
+
+		var req createAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{"Invalid JSON body"})
+			return
+		}
+
+		if strings.TrimSpace(req.Name) == "" || req.RateLimit < 0 {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{"name is required and rate_limit can't be negative"})
+			return
+		}
+
+		origins := make([]string, 0, len(req.AllowedOrigins))
+		for _, o := range req.AllowedOrigins {
+			o = strings.ToLower(strings.TrimSpace(o))
+			if !validOrigin(o) {
+				respond(w, http.StatusBadRequest, &invalidRequestResponse{"Invalid origin " + strconv.Quote(o)})
+				return
+			}
+			origins = append(origins, o)
+		}
+
+		id := randomToken(8)
+		k := &APIKey{
+			ID:             id,
+			Key:            id + "." + randomToken(24),
+			Name:           strings.TrimSpace(req.Name),
+			RateLimit:      req.RateLimit,
+			AllowedOrigins: origins,
+			CreatedAt:      time.Now(),
+		}
+
+		if err := s.apiKeys.SaveAPIKey(k, hashAPIKey(k.Key)); err != nil {
+			log.Printf("[routes] unable to save api key: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		// This is the only time the key gets handed out.
+		respond(w, http.StatusCreated, k)
+	}
+}
+
+func (s *server) handleGetAPIKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.apiKeys == nil {
+			respond(w, http.StatusServiceUnavailable, &invalidRequestResponse{"API keys are not supported"})
+			return
+		}
+
+		ks, err := s.apiKeys.AllAPIKeys()
+		if err != nil {
+			log.Printf("[routes] unable to load api keys: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		respond(w, http.StatusOK, ks)
+	}
+}
+
+func (s *server) handleDeleteAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.apiKeys == nil {
+			respond(w, http.StatusServiceUnavailable, &invalidRequestResponse{"API keys are not supported"})
+			return
+		}
+
+		if err := s.apiKeys.DeleteAPIKey(mux.Vars(r)["id"]); err != nil {
+			if err == ErrNotFound {
+				respond(w, http.StatusNotFound, &invalidRequestResponse{"No API key found"})
+				return
+			}
+
+			log.Printf("[routes] unable to delete api key: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		respond(w, http.StatusNoContent, nil)
+	}
+}
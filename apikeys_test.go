@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	s := newStorage(mr)
+
+	for i := 0; i < 2; i++ {
+		res, err := s.Take("::bucket::", 2, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed || res.Remaining != 1-i {
+			t.Errorf("request %d: expected to be allowed with %d remaining, got %+v", i, 1-i, res)
+		}
+	}
+
+	res, _ := s.Take("::bucket::", 2, time.Minute)
+	if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > 30*time.Second {
+		t.Errorf("expected request to be limited, got %+v", res)
+	}
+
+	res, _ = s.Take("::other-bucket::", 2, time.Minute)
+	if !res.Allowed {
+		t.Errorf("expected buckets to be independent, got %+v", res)
+	}
+}
+
+func TestTokenBucketKeepsSimilarNamesApart(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	s := newStorage(mr)
+
+	if res, _ := s.Take("ip:1.2.3.45", 1, time.Minute); !res.Allowed {
+		t.Fatalf("expected first client to be allowed, got %+v", res)
+	}
+
+	res, _ := s.Take("ip:12.3.4.5", 1, time.Minute)
+	if !res.Allowed {
+		t.Errorf("expected a different client to get its own bucket, got %+v", res)
+	}
+}
+
+func TestLimitAccess(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+
+	s := createServer()
+	s.storage = storage
+	s.apiKeys = storage
+	s.limiter = storage
+	s.adminToken = "::token::"
+	s.access = &accessConfig{anonymousLimit: 1, keyLimit: 5}
+
+	get := func(url string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", url, nil)
+		for k := range header {
+			r.Header.Set(k, header.Get(k))
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// Create a key with a custom limit via the admin API.
+	r := httptest.NewRequest("POST", "/admin/keys", bytes.NewBufferString(`{"name":"::client::","rate_limit":3}`))
+	r.Header.Set("Authorization", "Bearer ::token::")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unable to create api key: %d %s", w.Code, w.Body)
+	}
+	var key APIKey
+	json.NewDecoder(w.Body).Decode(&key)
+
+	t.Run("anonymous requests are limited per ip", func(t *testing.T) {
+		if w := get("/regions", nil); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "1" {
+			t.Errorf("expected first request to pass, got %d %v", w.Code, w.Header())
+		}
+
+		w := get("/regions", nil)
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("expected second request to be limited, got %d %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("health check is exempt", func(t *testing.T) {
+		if w := get("/ping", nil); w.Code != http.StatusOK {
+			t.Errorf("expected ping to pass, got %d", w.Code)
+		}
+	})
+
+	t.Run("keys use their own limit", func(t *testing.T) {
+		w := get("/regions?api_key="+key.Key, nil)
+		if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "3" || w.Header().Get("X-RateLimit-Remaining") != "2" {
+			t.Errorf("expected request with key to pass, got %d %v", w.Code, w.Header())
+		}
+
+		h := http.Header{}
+		h.Set(apiKeyHeader, key.Key)
+		w = get("/regions", h)
+		if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "1" {
+			t.Errorf("expected header and query to share the bucket, got %d %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("invalid keys are rejected", func(t *testing.T) {
+		if w := get("/regions?api_key=nope", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected invalid key to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("keys can be required", func(t *testing.T) {
+		s.access.requireKey = true
+		defer func() { s.access.requireKey = false }()
+
+		if w := get("/subregions", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected request without key to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("keys can be deleted", func(t *testing.T) {
+		keys, _ := storage.AllAPIKeys()
+		if len(keys) != 1 || keys[0].Key != "" || keys[0].Name != "::client::" {
+			t.Fatalf("expected stored key without secret, got %+v", keys)
+		}
+
+		r := httptest.NewRequest("DELETE", "/admin/keys/"+key.ID, nil)
+		r.Header.Set("Authorization", "Bearer ::token::")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("unable to delete key: %d", w.Code)
+		}
+
+		if w := get("/regions?api_key="+key.Key, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected deleted key to be rejected, got %d", w.Code)
+		}
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseNetworks([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &accessConfig{trustedProxies: proxies}
+
+	tests := []struct {
+		description string
+		remoteAddr  string
+		forwarded   []string
+		want        string
+	}{
+		{"direct request", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"header from untrusted client", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"behind a proxy", "10.0.0.2:1234", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"spoofed header behind a proxy", "10.0.0.2:1234", []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"behind several proxies", "192.0.2.1:1234", []string{"198.51.100.1, 203.0.113.7, 10.0.0.3"}, "203.0.113.7"},
+		{"several headers", "10.0.0.2:1234", []string{"198.51.100.1", "203.0.113.7"}, "203.0.113.7"},
+		{"only proxies", "10.0.0.2:1234", []string{"10.0.0.4, 10.0.0.3"}, "10.0.0.4"},
+		{"proxy without header", "10.0.0.2:1234", nil, "10.0.0.2"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/regions", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			if got := c.clientIP(r); got != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewEnvAccessConfig(t *testing.T) {
+	defer os.Unsetenv("RATE_LIMIT_ANONYMOUS")
+	defer os.Unsetenv("TRUSTED_PROXIES")
+
+	c, err := newEnvAccessConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.anonymousLimit != 0 || c.keyLimit != defaultKeyRateLimit {
+		t.Errorf("expected anonymous requests not to be limited by default, got %+v", c)
+	}
+
+	os.Setenv("RATE_LIMIT_ANONYMOUS", "60")
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, ::1")
+	if c, err = newEnvAccessConfig(); err != nil || c.anonymousLimit != 60 || len(c.trustedProxies) != 2 {
+		t.Errorf("expected configured limit and proxies, got %+v (%v)", c, err)
+	}
+
+	os.Setenv("TRUSTED_PROXIES", "proxy.local")
+	if _, err := newEnvAccessConfig(); err == nil {
+		t.Error("expected an error for an invalid proxy")
+	}
+}
+
+// brokenLimiter fails to check any limit.
+type brokenLimiter struct{}
+
+func (brokenLimiter) Take(bucket string, limit int, period time.Duration) (*rateLimitResult, error) {
+	return nil, errors.New("::connection refused::")
+}
+
+func TestLimitAccessFailsOpen(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	s := createServer()
+	s.storage = newStorage(mr)
+	s.limiter = brokenLimiter{}
+	s.access = &accessConfig{anonymousLimit: 1}
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/regions", nil))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected request %d to pass while limits can't be checked, got %d", i, w.Code)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxBatchSize is the maximum number of subregions a single batch
+// request may ask for.
+const maxBatchSize = 50
+
+type batchRequest struct {
+	Subregions []string `json:"subregions"`
+}
+
+// batchItem contains either the report of a subregion or the
+// reason why there is none.
+type batchItem struct {
+	Report      interface{} `json:"report,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Suggestions []string    `json:"suggestions,omitempty"`
+}
+
+type batchResult struct {
+	report      *PollenReport
+	suggestions []string
+}
+
+// handleGetBatch returns the reports of several subregions at
+// once. The subregions are either passed as a comma separated
+// list in the query or, for POST requests, as a JSON body.
+func (s *server) handleGetBatch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		view, msg := parseReportView(r)
+		if msg != "" {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{msg})
+			return
+		}
+
+		var req batchRequest
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				respond(w, http.StatusBadRequest, &invalidRequestResponse{"Invalid JSON body"})
+				return
+			}
+		} else {
+			req.Subregions = splitList(r.URL.Query().Get("subregions"))
+		}
+
+		subregions := uniqueSubregions(req.Subregions)
+		if len(subregions) == 0 {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{"subregions is required"})
+			return
+		}
+		if len(subregions) > maxBatchSize {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{fmt.Sprintf("at most %d subregions can be requested at once", maxBatchSize)})
+			return
+		}
+
+		reports, err := s.storage.GetBySubregions(subregions)
+		if err != nil {
+			log.Printf("[routes] unable to load reports: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		rs := make([]*batchResult, len(reports))
+		for i, r := range reports {
+			rs[i] = &batchResult{report: r}
+		}
+
+		if err := s.resolveMissing(subregions, rs); err != nil {
+			log.Printf("[routes] unable to resolve subregions: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		result := make(map[string]*batchItem, len(subregions))
+		for i, subregion := range subregions {
+			if rs[i].report == nil {
+				result[subregion] = &batchItem{Error: "No data found", Suggestions: rs[i].suggestions}
+				continue
+			}
+			result[subregion] = &batchItem{Report: view.render(view.filter([]*PollenReport{rs[i].report})[0])}
+		}
+
+		respond(w, http.StatusOK, result)
+	}
+}
+
+// resolveMissing looks up the subregions without a report by the
+// name the user probably meant. All of them are loaded at once.
+// Subregions which still can't be found get suggestions.
+func (s *server) resolveMissing(subregions []string, rs []*batchResult) error {
+	var res *nameResolver
+	var keys []string
+	var missing []int
+
+	for i, r := range rs {
+		if r.report != nil {
+			continue
+		}
+
+		if res == nil {
+			var err error
+			if res, err = s.subregionResolver(); err != nil {
+				return err
+			}
+		}
+
+		key, ok := res.resolve(subregions[i])
+		if !ok || key == normalizeString(subregions[i]) {
+			r.suggestions = res.suggest(subregions[i])
+			continue
+		}
+		keys = append(keys, key)
+		missing = append(missing, i)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	reports, err := s.storage.GetBySubregions(keys)
+	if err != nil {
+		return err
+	}
+	for j, i := range missing {
+		rs[i].report = reports[j]
+	}
+
+	return nil
+}
+
+// uniqueSubregions removes empty and duplicate subregions, keeping
+// the order they were requested in.
+func uniqueSubregions(subregions []string) []string {
+	seen := make(map[string]bool)
+
+	var unique []string
+	for _, subregion := range subregions {
+		subregion = strings.TrimSpace(subregion)
+		if subregion == "" || seen[subregion] {
+			continue
+		}
+		seen[subregion] = true
+		unique = append(unique, subregion)
+	}
+
+	return unique
+}
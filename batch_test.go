@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatchEndpoint(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	s := createServer()
+	s.storage = newStorage(mr)
+
+	tooMany := make([]string, maxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("subregion-%d", i)
+	}
+
+	testCases := []struct {
+		description string
+		method      string
+		url         string
+		body        string
+		status      int
+		found       []string
+		missing     []string
+	}{
+		{
+			"query",
+			"GET",
+			"/pollen/batch?subregions=subregion-aa,subregion-ba,nope",
+			"",
+			http.StatusOK,
+			[]string{"subregion-aa", "subregion-ba"},
+			[]string{"nope"},
+		},
+		{
+			"json body",
+			"POST",
+			"/pollen/batch",
+			`{"subregions": ["subregion-ab", "region-c", "subregion-ab"]}`,
+			http.StatusOK,
+			[]string{"subregion-ab", "region-c"},
+			nil,
+		},
+		{
+			"misspelled subregions",
+			"GET",
+			"/pollen/batch?subregions=Subregion-AA,subregion-ax",
+			"",
+			http.StatusOK,
+			[]string{"Subregion-AA"},
+			[]string{"subregion-ax"},
+		},
+		{
+			"no subregions",
+			"GET",
+			"/pollen/batch",
+			"",
+			http.StatusBadRequest,
+			nil,
+			nil,
+		},
+		{
+			"invalid body",
+			"POST",
+			"/pollen/batch",
+			`{"subregions": "subregion-aa"}`,
+			http.StatusBadRequest,
+			nil,
+			nil,
+		},
+		{
+			"too many subregions",
+			"GET",
+			"/pollen/batch?subregions=" + strings.Join(tooMany, ","),
+			"",
+			http.StatusBadRequest,
+			nil,
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, w.Code, w.Body)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var got map[string]struct {
+				Report *PollenReport `json:"report"`
+				Error  string        `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tc.found)+len(tc.missing) {
+				t.Errorf("expected %d items, got %d", len(tc.found)+len(tc.missing), len(got))
+			}
+			for _, subregion := range tc.found {
+				if item, ok := got[subregion]; !ok || item.Report == nil || item.Error != "" {
+					t.Errorf("expected report for %s, got %+v", subregion, item)
+				}
+			}
+			for _, subregion := range tc.missing {
+				if item, ok := got[subregion]; !ok || item.Report != nil || item.Error == "" {
+					t.Errorf("expected error for %s, got %+v", subregion, item)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCacheTTL = 5 * time.Minute
+
+	// maxCachedResponses limits the memory used by the cache, since
+	// every distinct query string results in a new entry.
+	maxCachedResponses = 1024
+
+	// compressMinSize is the size from which on responses get
+	// compressed. Smaller responses aren't worth the effort.
+	compressMinSize = 512
+
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// compressionEncodings are the supported content encodings, in the
+// order they are preferred.
+var compressionEncodings = []string{encodingBrotli, encodingGzip}
+
+// responseCache keeps serialized responses in memory, so repeated
+// requests don't have to hit the storage. The data only changes
+// after a sync, so the cache gets purged whenever reports get
+// synced. The TTL is a safety net in case an update gets lost.
+type responseCache struct {
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]*cachedResponse
+}
+
+// cachedResponse is a response ready to be written. Large
+// responses are compressed ahead of time as well.
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	encoded map[string][]byte
+	expires time.Time
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{
+		ttl:     ttl,
+		entries: make(map[string]*cachedResponse),
+	}
+}
+
+// newEnvResponseCache returns a cache configured via environment
+// variables. It returns nil if caching is disabled.
+func newEnvResponseCache() (*responseCache, error) {
+	ttl := defaultCacheTTL
+	if v, exists := os.LookupEnv("RESPONSE_CACHE_TTL"); exists {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, errors.Errorf("invalid RESPONSE_CACHE_TTL %q", v)
+		}
+		ttl = d
+	}
+
+	if ttl == 0 {
+		return nil, nil
+	}
+
+	return newResponseCache(ttl), nil
+}
+
+func (c *responseCache) get(key string) *cachedResponse {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil
+	}
+	return e
+}
+
+func (c *responseCache) put(key string, e *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCachedResponses {
+		now := time.Now()
+		for k, old := range c.entries {
+			if now.After(old.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCachedResponses {
+			return
+		}
+	}
+
+	e.expires = time.Now().Add(c.ttl)
+	c.entries[key] = e
+}
+
+// purge removes all cached responses.
+func (c *responseCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*cachedResponse)
+}
+
+// ReportsSynced implements SyncObserver. Even if no forecast
+// changed, the reports might have a new last update.
+func (c *responseCache) ReportsSynced(updates []*ReportUpdate) {
+	for _, u := range updates {
+		if u.Updated() {
+			c.purge()
+			return
+		}
+	}
+}
+
+// listen purges the cache whenever another instance publishes
+// updated reports.
+func (c *responseCache) listen(bus UpdateBus) {
+	ch, _ := bus.Subscribe()
+	for range ch {
+		c.purge()
+	}
+}
+
+// cacheKey identifies a response. The API key doesn't influence
+// the response, so it is left out.
+func cacheKey(r *http.Request) string {
+	q := r.URL.Query()
+	q.Del(apiKeyParam)
+
+	format, _ := negotiateFormat(r)
+	return format + " " + r.URL.Path + "?" + q.Encode()
+}
+
+// cached serves successful responses of h from the cache.
+// Responses served from the snapshot while the storage is down
+// aren't cached, they would outlive the outage.
+func (s *server) cached(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cache == nil {
+			h(w, r)
+			return
+		}
+
+		key := cacheKey(r)
+		if e := s.cache.get(key); e != nil {
+			w.Header().Set("X-Cache", "HIT")
+			e.write(w, r, http.StatusOK)
+			return
+		}
+
+		degraded := s.degraded()
+
+		rec := newResponseRecorder()
+		h(rec, r)
+
+		e := newCachedResponse(rec.header, rec.body.Bytes())
+		if rec.status == http.StatusOK && !degraded {
+			s.cache.put(key, e)
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		e.write(w, r, rec.status)
+	}
+}
+
+// newCachedResponse returns the response with its body compressed
+// in every supported encoding.
+func newCachedResponse(header http.Header, body []byte) *cachedResponse {
+	e := &cachedResponse{header: header, body: body}
+	if len(body) < compressMinSize {
+		return e
+	}
+
+	e.encoded = make(map[string][]byte)
+	for _, encoding := range compressionEncodings {
+		e.encoded[encoding] = compress(encoding, body)
+	}
+
+	return e
+}
+
+// compressResponses compresses the responses of all routes other
+// than streams, which have to be sent as they are written. Cached
+// responses are compressed already and get passed through.
+func (s *server) compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.streams[mux.CurrentRoute(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := newResponseRecorder()
+		next.ServeHTTP(rec, r)
+
+		if rec.header.Get("Content-Encoding") != "" || rec.header.Get("X-Cache") != "" {
+			rec.writeTo(w)
+			return
+		}
+
+		e := &cachedResponse{header: rec.header, body: rec.body.Bytes()}
+		if encoding := negotiateEncoding(r); encoding != "" && len(e.body) >= compressMinSize {
+			e.encoded = map[string][]byte{encoding: compress(encoding, e.body)}
+		}
+		e.write(w, r, rec.status)
+	})
+}
+
+// write copies the response to w. The body is compressed if the
+// client accepts one of the encodings.
+func (e *cachedResponse) write(w http.ResponseWriter, r *http.Request, status int) {
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	body := e.body
+	if encoding := negotiateEncoding(r); e.encoded[encoding] != nil {
+		w.Header().Set("Content-Encoding", encoding)
+		body = e.encoded[encoding]
+	}
+
+	if status != http.StatusNoContent && status != http.StatusNotModified {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// compress returns the data in the encoding, or nil if it can't
+// be compressed.
+func compress(encoding string, data []byte) []byte {
+	var (
+		buf bytes.Buffer
+		zw  io.WriteCloser
+	)
+
+	switch encoding {
+	case encodingBrotli:
+		zw = brotli.NewWriter(&buf)
+	case encodingGzip:
+		zw = gzip.NewWriter(&buf)
+	default:
+		return nil
+	}
+
+	if _, err := zw.Write(data); err != nil {
+		log.Printf("[cache] unable to compress response: %q", err.Error())
+		return nil
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("[cache] unable to compress response: %q", err.Error())
+		return nil
+	}
+
+	return buf.Bytes()
+}
+
+// negotiateEncoding returns the supported encoding with the highest
+// quality in the Accept-Encoding header of the request. On a tie,
+// brotli wins since it compresses better. It returns an empty
+// string if the client doesn't accept any of them.
+func negotiateEncoding(r *http.Request) string {
+	best, quality := "", 0.0
+	for _, encoding := range compressionEncodings {
+		if q := encodingQuality(r, encoding); q > quality {
+			best, quality = encoding, q
+		}
+	}
+	return best
+}
+
+// encodingQuality returns the quality the Accept-Encoding header of
+// the request assigns to the encoding. Encodings which aren't
+// listed have a quality of 0, unless there is a wildcard.
+func encodingQuality(r *http.Request, encoding string) float64 {
+	wildcard := 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		name := strings.TrimSpace(params[0])
+
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		if strings.EqualFold(name, encoding) {
+			return q
+		}
+		if name == "*" {
+			wildcard = q
+		}
+	}
+
+	return wildcard
+}
+
+// responseRecorder captures a response so it can be cached.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{
+		header: make(http.Header),
+		status: http.StatusOK,
+	}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+}
+
+// writeTo copies the recorded response to w as it is.
+func (r *responseRecorder) writeTo(w http.ResponseWriter) {
+	for k, v := range r.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(r.status)
+	w.Write(r.body.Bytes())
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestResponseCache(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+	storage.Save(createPollenReport("::region-a::", "::subregion-a::"))
+
+	s := createServer()
+	s.storage = storage
+	s.cache = newResponseCache(time.Minute)
+
+	get := func(url string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", url, nil)
+		for k, v := range header {
+			r.Header.Set(k, v[0])
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	first := get("/pollen", nil)
+	if first.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("expected first request to miss the cache, got %v", first.Header())
+	}
+
+	// Changes in the storage aren't visible until the cache gets purged.
+	storage.Save(createPollenReport("::region-a::", "::subregion-b::"))
+
+	second := get("/pollen", nil)
+	if second.Header().Get("X-Cache") != "HIT" || second.Body.String() != first.Body.String() {
+		t.Errorf("expected second request to be served from the cache, got %v %s", second.Header(), second.Body)
+	}
+
+	if w := get("/pollen?format=csv", nil); w.Header().Get("X-Cache") != "MISS" || w.Header().Get("Content-Type") != formatContentTypes[formatCSV] {
+		t.Errorf("expected formats to be cached separately, got %v", w.Header())
+	}
+
+	if w := get("/pollen/subregion/nope", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+	if w := get("/pollen/subregion/nope", nil); w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("expected errors not to be cached, got %v", w.Header())
+	}
+
+	s.cache.ReportsSynced([]*ReportUpdate{{}})
+
+	third := get("/pollen", nil)
+	if third.Header().Get("X-Cache") != "MISS" || third.Body.String() == first.Body.String() {
+		t.Errorf("expected sync to purge the cache, got %v %s", third.Header(), third.Body)
+	}
+
+	t.Run("gzip", func(t *testing.T) {
+		w := get("/pollen", http.Header{"Accept-Encoding": []string{"br;q=0.5, gzip;q=0.8"}})
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("expected gzipped response, got %v", w.Header())
+		}
+
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != third.Body.String() {
+			t.Errorf("expected decompressed body to match, got %s", body)
+		}
+	})
+
+	t.Run("brotli", func(t *testing.T) {
+		w := get("/pollen", http.Header{"Accept-Encoding": []string{"gzip, deflate, br"}})
+		if w.Header().Get("Content-Encoding") != "br" || w.Header().Get("X-Cache") != "HIT" {
+			t.Fatalf("expected brotli compressed response from the cache, got %v", w.Header())
+		}
+
+		body, err := ioutil.ReadAll(brotli.NewReader(w.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != third.Body.String() {
+			t.Errorf("expected decompressed body to match, got %s", body)
+		}
+	})
+
+	t.Run("new last update", func(t *testing.T) {
+		get("/pollen", nil)
+
+		previous := createPollenReport("::region-a::", "::subregion-a::")
+		current := *previous
+		current.LastUpdate = previous.LastUpdate.Add(24 * time.Hour)
+		s.cache.ReportsSynced([]*ReportUpdate{{previous, &current}})
+
+		if w := get("/pollen", nil); w.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("expected a new last update to purge the cache, got %v", w.Header())
+		}
+	})
+}
+
+func TestUncachedResponsesAreCompressed(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+
+	s := createServer()
+	s.storage = storage
+
+	r := httptest.NewRequest("GET", "/pollen", nil)
+	r.Header.Set("Accept-Encoding", "br")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Header().Get("Content-Encoding") != "br" || !strings.Contains(strings.Join(w.Header()["Vary"], ","), "Accept-Encoding") {
+		t.Fatalf("expected brotli compressed response, got %v", w.Header())
+	}
+
+	body, err := ioutil.ReadAll(brotli.NewReader(w.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reports []*PollenReport
+	if err := json.Unmarshal(body, &reports); err != nil || len(reports) != 4 {
+		t.Errorf("expected 4 reports, got %d (%v)", len(reports), err)
+	}
+}
+
+func TestDegradedResponsesAreNotCached(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	primary := &flakyStorage{RedisStorage: newStorage(mr)}
+	f := NewFallbackStorage(primary, "")
+
+	s := createServer()
+	s.storage = f
+	s.fallback = f
+	s.cache = newResponseCache(time.Minute)
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/regions", nil))
+		return w
+	}
+
+	primary.down = true
+	f.setAvailable(false)
+
+	get()
+	if w := get(); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("expected stale response not to be cached, got %d %v", w.Code, w.Header())
+	}
+
+	primary.down = false
+	f.setAvailable(true)
+
+	get()
+	if w := get(); w.Header().Get("X-Cache") != "HIT" || w.Header().Get("Warning") != "" {
+		t.Errorf("expected fresh response to be cached, got %v", w.Header())
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, GZIP", "gzip"},
+		{"gzip;q=0", ""},
+		{"gzip, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, *", "gzip"},
+		{"*", "br"},
+		{"deflate", ""},
+	}
+
+	for _, tc := range testCases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", tc.header)
+
+		if actual := negotiateEncoding(r); actual != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.header, tc.expected, actual)
+		}
+	}
+}
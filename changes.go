@@ -0,0 +1,178 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// changeRetention is how long changes are kept around.
+const changeRetention = 30 * 24 * time.Hour
+
+// Change describes how the forecast of a single species changed
+// between two consecutive syncs.
+type Change struct {
+	Source     string    `json:"source"`
+	Region     string    `json:"region"`
+	SubRegion  string    `json:"sub_region"`
+	Species    string    `json:"species"`
+	Name       string    `json:"name"`
+	Day        string    `json:"day"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Delta      float64   `json:"delta"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// ChangeStorage defines a type that can save and retrieve the
+// changes detected during syncs.
+type ChangeStorage interface {
+	SaveChanges(changes []*Change) error
+	ChangesSince(since time.Time) ([]*Change, error)
+}
+
+// changeRecorder saves the changes between the previous and the
+// current report of every sync.
+type changeRecorder struct {
+	storage ChangeStorage
+}
+
+// ReportsSynced implements SyncObserver.
+func (c *changeRecorder) ReportsSynced(updates []*ReportUpdate) {
+	now := time.Now()
+
+	var changes []*Change
+	for _, u := range updates {
+		changes = append(changes, diffReports(u.Previous, u.Current, now)...)
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+
+	if err := c.storage.SaveChanges(changes); err != nil {
+		log.Printf("[changes] unable to save changes: %q", err.Error())
+	}
+}
+
+// diffReports returns the changes for every species and day
+// whose severity differs between the two reports. If there is
+// no previous report, there is nothing to compare against.
+func diffReports(previous, current *PollenReport, at time.Time) []*Change {
+	if previous == nil {
+		return nil
+	}
+
+	var changes []*Change
+	for _, p := range current.Pollen {
+		prev := previous.species(p.Slug)
+
+		for _, d := range p.days() {
+			if d.report == nil {
+				continue
+			}
+
+			var from string
+			if prev != nil {
+				if prevDay := prev.day(d.name); prevDay != nil {
+					from = prevDay.Severity
+				}
+			}
+
+			if from == d.report.Severity {
+				continue
+			}
+
+			fromLevel, _ := severityLevel(from)
+			toLevel, _ := severityLevel(d.report.Severity)
+
+			changes = append(changes, &Change{
+				Source:     current.source(),
+				Region:     current.Region,
+				SubRegion:  current.SubRegion,
+				Species:    p.Slug,
+				Name:       p.Name,
+				Day:        d.name,
+				From:       from,
+				To:         d.report.Severity,
+				Delta:      toLevel - fromLevel,
+				DetectedAt: at,
+			})
+		}
+	}
+
+	return changes
+}
+
+func (c *Change) report() *PollenReport {
+	return &PollenReport{Source: c.Source, Region: c.Region, SubRegion: c.SubRegion}
+}
+
+func (c *Change) key() string {
+	return c.report().Key()
+}
+
+func (s *server) handleGetChanges() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.changes == nil {
+			respond(w, http.StatusServiceUnavailable, &invalidRequestResponse{"Change detection is not supported"})
+			return
+		}
+
+		q := r.URL.Query()
+
+		since := time.Now().Add(-24 * time.Hour)
+		if v := q.Get("since"); v != "" {
+			t, ok := parseSince(v)
+			if !ok {
+				respond(w, http.StatusBadRequest, &invalidRequestResponse{"since has to be a RFC 3339 timestamp or a unix timestamp"})
+				return
+			}
+			since = t
+		}
+
+		changes, err := s.changes.ChangesSince(since)
+		if err != nil {
+			log.Printf("[routes] unable to load changes: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		region := normalizeString(q.Get("region"))
+		subregion := normalizeString(q.Get("subregion"))
+		source := strings.ToLower(q.Get("source"))
+		if source != "" && subregion != "" {
+			subregion = sourceKey(source, subregion)
+		}
+
+		result := make([]*Change, 0, len(changes))
+		for _, c := range changes {
+			if source != "" && source != c.report().source() {
+				continue
+			}
+			if region != "" && !strings.EqualFold(region, normalizeString(c.Region)) {
+				continue
+			}
+			if subregion != "" && !strings.EqualFold(subregion, c.key()) {
+				continue
+			}
+			result = append(result, c)
+		}
+
+		respondExport(w, r, http.StatusOK, changeList(result), result)
+	}
+}
+
+func parseSince(v string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+
+	if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(unix, 0), true
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffReports(t *testing.T) {
+	at := time.Date(2020, 3, 1, 11, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+		previous    *PollenReport
+		current     *PollenReport
+		want        []*Change
+	}{
+		{
+			"no previous report",
+			nil,
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "2"}),
+			nil,
+		},
+		{
+			"nothing changed",
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "2"}),
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "2"}),
+			nil,
+		},
+		{
+			"severity increased",
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "1"}),
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "2-3"}),
+			[]*Change{
+				{
+					Source:     sourceDWD,
+					Region:     "region-a",
+					SubRegion:  "subregion-aa",
+					Species:    "birke",
+					Name:       "birke",
+					Day:        dayToday,
+					From:       "1",
+					To:         "2-3",
+					Delta:      1.5,
+					DetectedAt: at,
+				},
+			},
+		},
+		{
+			"new species",
+			reportWithSeverities("subregion-aa", map[string]string{}),
+			reportWithSeverities("subregion-aa", map[string]string{"ulme": "1"}),
+			[]*Change{
+				{sourceDWD, "region-a", "subregion-aa", "ulme", "ulme", dayToday, "", "1", 1, at},
+				{sourceDWD, "region-a", "subregion-aa", "ulme", "ulme", dayTomorrow, "", "0", 0, at},
+				{sourceDWD, "region-a", "subregion-aa", "ulme", "ulme", dayDayAfterTomorrow, "", "0", 0, at},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			got := diffReports(tc.previous, tc.current, at)
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestChangeStorage(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	s := newStorage(mr)
+
+	now := time.Now().Truncate(time.Second)
+	changes := []*Change{
+		{SubRegion: "old", DetectedAt: now.Add(-2 * time.Hour)},
+		{SubRegion: "new", DetectedAt: now},
+		{SubRegion: "expired", DetectedAt: now.Add(-changeRetention - time.Hour)},
+	}
+	if err := s.SaveChanges(changes); err != nil {
+		t.Fatalf("unable to save changes: %q", err)
+	}
+
+	got, err := s.ChangesSince(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].SubRegion != "new" {
+		t.Errorf("expected only the new change, got %+v", got)
+	}
+
+	got, _ = s.ChangesSince(time.Time{})
+	if len(got) != 2 {
+		t.Errorf("expected expired change to be removed, got %+v", got)
+	}
+}
+
+func TestUnchangedReportsAreNotRewritten(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	s := newStorage(mr)
+
+	data, _ := json.Marshal(upstreamResponse)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	syncer := &Syncer{
+		upstream: &httpUpstream{server.URL},
+		storage:  s,
+	}
+	syncer.Observe(&changeRecorder{s})
+
+	first := syncer.Sync(triggerSchedule)
+	second := syncer.Sync(triggerSchedule)
+
+	if first.Saved != 1 || second.Saved != 0 || second.Unchanged != 1 {
+		t.Errorf("expected second run to skip the unchanged report, got %+v and %+v", first, second)
+	}
+
+	changes, _ := s.ChangesSince(time.Time{})
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
+func TestGetChanges(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+
+	now := time.Now().Truncate(time.Second)
+	storage.SaveChanges([]*Change{
+		{Region: "region-a", SubRegion: "subregion-aa", Species: "birke", DetectedAt: now},
+		{Region: "region-a", SubRegion: "subregion-ab", Species: "birke", DetectedAt: now},
+		{Region: "region-a", SubRegion: "subregion-aa", Species: "hasel", DetectedAt: now.Add(-48 * time.Hour)},
+		{Source: "austria", Region: "Tirol", SubRegion: "subregion-aa", Species: "birke", DetectedAt: now},
+	})
+
+	s := createServer()
+	s.changes = storage
+
+	tests := []struct {
+		description string
+		query       string
+		status      int
+		want        int
+	}{
+		{"defaults to the last day", "", http.StatusOK, 3},
+		{"since unix timestamp", "?since=0", http.StatusOK, 4},
+		{"since RFC 3339", "?since=" + now.Add(-72*time.Hour).Format(time.RFC3339), http.StatusOK, 4},
+		{"filtered by subregion", "?since=0&subregion=Subregion-AA", http.StatusOK, 2},
+		{"filtered by subregion of a source", "?since=0&subregion=austria:subregion-aa", http.StatusOK, 1},
+		{"filtered by subregion and source", "?since=0&subregion=subregion-aa&source=austria", http.StatusOK, 1},
+		{"filtered by source", "?since=0&source=dwd", http.StatusOK, 3},
+		{"invalid since", "?since=yesterday", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", "/pollen/changes"+tc.query, nil))
+
+			if w.Code != tc.status {
+				t.Fatalf("wanted status %d, got %d", tc.status, w.Code)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var got []*Change
+			json.NewDecoder(w.Body).Decode(&got)
+			if len(got) != tc.want {
+				t.Errorf("wanted %d changes, got %+v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+// city is a place users search for which isn't a region or
+// subregion itself.
+type city struct {
+	Name string
+	// PostalCodes is the range of the first three digits of the
+	// city's postal codes, e.g. "603-605".
+	PostalCodes string
+	// Subregion is the subregion the city is part of.
+	Subregion string
+}
+
+// bundledCities maps larger cities to the subregion they are part
+// of. The subregions follow the areas of the DWD's forecast and
+// don't match administrative borders, so this is a best effort.
+// Cities whose subregion doesn't exist are ignored.
+var bundledCities = []*city{
+	{"Aachen", "520-520", "Rhein_Westfäl_Tiefland"},
+	{"Augsburg", "861-861", "Donauniederungen"},
+	{"Berlin", "101-141", "Brandenburg_und_Berlin"},
+	{"Bielefeld", "336-337", "Ostwestfalen"},
+	{"Bochum", "447-448", "Rhein_Westfäl_Tiefland"},
+	{"Bonn", "531-532", "Rhein_Westfäl_Tiefland"},
+	{"Bremen", "281-287", "Westl_Niedersachsen_Bremen"},
+	{"Chemnitz", "091-091", "Mittelgebirge_Sachsen"},
+	{"Darmstadt", "642-642", "Rhein_Main"},
+	{"Dortmund", "441-443", "Rhein_Westfäl_Tiefland"},
+	{"Dresden", "010-013", "Tiefland_Sachsen"},
+	{"Duisburg", "470-472", "Rhein_Westfäl_Tiefland"},
+	{"Düsseldorf", "402-406", "Rhein_Westfäl_Tiefland"},
+	{"Erfurt", "990-991", "Tiefland_Thüringen"},
+	{"Essen", "451-453", "Rhein_Westfäl_Tiefland"},
+	{"Flensburg", "249-249", "Geest_Schleswig_Holstein_und_Hamburg"},
+	{"Frankfurt am Main", "603-605", "Rhein_Main"},
+	{"Freiburg im Breisgau", "790-791", "Oberrhein_und_unteres_Neckartal"},
+	{"Garmisch-Partenkirchen", "824-824", "Allgäu_Oberbayern_Bay_Wald"},
+	{"Goslar", "386-386", "Harz"},
+	{"Göttingen", "370-370", "Östl_Niedersachsen"},
+	{"Halle (Saale)", "061-061", "Tiefland_Sachsen_Anhalt"},
+	{"Hamburg", "200-227", "Geest_Schleswig_Holstein_und_Hamburg"},
+	{"Hannover", "301-306", "Östl_Niedersachsen"},
+	{"Heidelberg", "691-691", "Oberrhein_und_unteres_Neckartal"},
+	{"Husum", "258-258", "Inseln_und_Marschen"},
+	{"Ingolstadt", "850-850", "Donauniederungen"},
+	{"Karlsruhe", "761-762", "Oberrhein_und_unteres_Neckartal"},
+	{"Kassel", "341-341", "Nordhessen_und_hess_Mittelgebirge"},
+	{"Kiel", "241-241", "Geest_Schleswig_Holstein_und_Hamburg"},
+	{"Koblenz", "560-560", "Rhein_Pfalz_Nahe_und_Mosel"},
+	{"Köln", "506-511", "Rhein_Westfäl_Tiefland"},
+	{"Leipzig", "041-043", "Tiefland_Sachsen"},
+	{"Lübeck", "235-235", "Geest_Schleswig_Holstein_und_Hamburg"},
+	{"Magdeburg", "391-391", "Tiefland_Sachsen_Anhalt"},
+	{"Mainz", "551-551", "Rhein_Pfalz_Nahe_und_Mosel"},
+	{"Mannheim", "681-683", "Oberrhein_und_unteres_Neckartal"},
+	{"München", "803-819", "Allgäu_Oberbayern_Bay_Wald"},
+	{"Münster", "481-481", "Rhein_Westfäl_Tiefland"},
+	{"Nürnberg", "904-904", "Bayern_nördl_der_Donau_o_Bayr_Wald_o_Mainfranken"},
+	{"Oldenburg", "261-261", "Westl_Niedersachsen_Bremen"},
+	{"Osnabrück", "490-490", "Westl_Niedersachsen_Bremen"},
+	{"Potsdam", "144-144", "Brandenburg_und_Berlin"},
+	{"Regensburg", "930-930", "Donauniederungen"},
+	{"Rostock", "180-181", "Mecklenburg_Vorpommern"},
+	{"Saarbrücken", "661-661", "Saarland"},
+	{"Schwerin", "190-190", "Mecklenburg_Vorpommern"},
+	{"Siegen", "570-570", "Mittelgebirge_NRW"},
+	{"Stuttgart", "701-706", "Hohenlohe_mittlerer_Neckar_Oberschwaben"},
+	{"Trier", "542-542", "Rhein_Pfalz_Nahe_und_Mosel"},
+	{"Ulm", "890-890", "Hohenlohe_mittlerer_Neckar_Oberschwaben"},
+	{"Wernigerode", "388-388", "Harz"},
+	{"Westerland", "259-259", "Inseln_und_Marschen"},
+	{"Wiesbaden", "651-652", "Rhein_Main"},
+	{"Wuppertal", "421-423", "Mittelgebirge_NRW"},
+	{"Würzburg", "970-970", "Mainfranken"},
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/urfave/negroni"
+)
+
+// dump is the format written by the dump command.
+type dump struct {
+	Regions    []string        `json:"regions"`
+	Subregions []string        `json:"subregions"`
+	Reports    []*PollenReport `json:"reports"`
+}
+
+// runCommand starts the API server and keeps the storage
+// up to date in the background.
+func runCommand(args []string) error {
+	storage, err := connectServingStorage()
+	if err != nil {
+		return err
+	}
+
+	syncers, err := NewEnvSyncers(storage)
+	if err != nil {
+		return err
+	}
+
+	bus := updateBus(storage)
+	for _, syncer := range syncers {
+		observeSyncs(syncer, storage, bus)
+	}
+
+	return serve(storage, syncers, bus)
+}
+
+// serveCommand only starts the API server. Syncing has to be
+// taken care of by a separate process, e.g. the sync command.
+func serveCommand(args []string) error {
+	storage, err := connectServingStorage()
+	if err != nil {
+		return err
+	}
+
+	return serve(storage, nil, updateBus(storage))
+}
+
+// syncCommand performs a single sync run per source and exits.
+// A failed run results in a non-zero exit code so it can be used
+// from cron jobs. When replaying a directory of snapshots, every
+// snapshot gets synced before exiting.
+func syncCommand(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	name := fs.String("name", "", "only sync the source with this name")
+	source := fs.String("source", "", "URL, file or directory to read data from")
+	replay := fs.Bool("replay", false, "feed all snapshots in the source directory in order")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	storage, err := connectStorage()
+	if err != nil {
+		return err
+	}
+
+	syncers, err := NewEnvSyncers(storage)
+	if err != nil {
+		return err
+	}
+
+	if *name != "" {
+		var selected []*Syncer
+		for _, syncer := range syncers {
+			if syncer.dataSource().Name() == strings.ToLower(*name) {
+				selected = append(selected, syncer)
+			}
+		}
+		if len(selected) == 0 {
+			return errors.Errorf("source %q is not configured", *name)
+		}
+		syncers = selected
+	}
+
+	if *source != "" {
+		if len(syncers) != 1 {
+			return errors.New("-source requires -name if multiple sources are configured")
+		}
+
+		u, err := newUpstream(*source, *replay)
+		if err != nil {
+			return err
+		}
+		syncers[0].upstream = u
+	}
+
+	bus := updateBus(storage)
+	for _, syncer := range syncers {
+		// Make sure all webhooks have been called before exiting.
+		if notifier := observeSyncs(syncer, storage, bus); notifier != nil {
+			defer notifier.Wait()
+		}
+
+		if err := syncOnce(syncer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncOnce syncs until the syncer's upstream has no more data.
+func syncOnce(syncer *Syncer) error {
+	for {
+		run := syncer.Sync(triggerManual)
+		if run.Error != "" {
+			return errors.Errorf("%s: %s", run.Source, run.Error)
+		}
+
+		log.Printf("[main] synced %d reports from %s (last update %s)", run.Saved, run.Source, run.LastUpdate)
+
+		if _, ok := syncer.upstream.(*replayUpstream); !ok || syncer.replayFinished() {
+			return nil
+		}
+	}
+}
+
+// dumpCommand writes everything in the storage as JSON.
+func dumpCommand(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	out := fs.String("o", "", "write to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	storage, err := connectStorage()
+	if err != nil {
+		return err
+	}
+
+	var d dump
+	if d.Regions, err = storage.AllRegions(); err != nil {
+		return err
+	}
+	if d.Subregions, err = storage.AllSubregions(); err != nil {
+		return err
+	}
+	if d.Reports, err = storage.AllReports(); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&d)
+}
+
+// importCommand loads a file in the DWD opendata format from
+// disk and saves its reports to the storage.
+func importCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("import expects exactly one file")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	storage, err := connectStorage()
+	if err != nil {
+		return err
+	}
+
+	run := NewSyncer(storage, 0).Import(f)
+	if run.Error != "" {
+		return errors.New(run.Error)
+	}
+
+	log.Printf("[main] imported %d reports from %s", run.Saved, args[0])
+	return nil
+}
+
+// observeSyncs registers everything that needs to happen after
+// a sync. It returns the webhook notifier, if the storage
+// supports subscriptions.
+func observeSyncs(syncer *Syncer, storage Storage, bus UpdateBus) *WebhookNotifier {
+	syncer.Observe(&updatePublisher{bus})
+
+	if cs, ok := storage.(ChangeStorage); ok {
+		syncer.Observe(&changeRecorder{cs})
+	}
+	if hs, ok := storage.(HistoryStorage); ok {
+		syncer.Observe(&historyRecorder{hs})
+	}
+
+	ss, ok := storage.(SubscriptionStorage)
+	if !ok {
+		return nil
+	}
+
+	notifier := NewWebhookNotifier(ss)
+	syncer.Observe(notifier)
+
+	return notifier
+}
+
+// updateBus returns the storage if it can distribute updates
+// between instances. Otherwise updates stay within this process.
+func updateBus(storage Storage) UpdateBus {
+	if bus, ok := storage.(UpdateBus); ok {
+		return bus
+	}
+	return newLocalBus()
+}
+
+var errStorageUnreachable = errors.New("[main] unable to connect to configured storage")
+
+// connectStorage connects to the configured storage. Since the
+// storage might still be starting up, it retries for a while.
+func connectStorage() (Storage, error) {
+	timeout := defaultConnectTimeout
+	if v, exists := os.LookupEnv("STORAGE_CONNECT_TIMEOUT"); exists {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid STORAGE_CONNECT_TIMEOUT")
+		}
+		timeout = d
+	}
+
+	storage, err := connectWithRetry(NewEnvStorage, timeout)
+	if err != nil {
+		if err == ErrCouldNotConnectToStorage {
+			return nil, errStorageUnreachable
+		}
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// connectServingStorage connects to the storage for serving the
+// API. If the storage can't be reached but there is a snapshot,
+// the server starts anyway and serves the snapshot until the
+// storage is up.
+func connectServingStorage() (Storage, error) {
+	storage, err := connectStorage()
+	if err != errStorageUnreachable {
+		return storage, err
+	}
+
+	file := os.Getenv("STORAGE_SNAPSHOT_FILE")
+	if file == "" {
+		return nil, err
+	}
+	if _, statErr := os.Stat(file); statErr != nil {
+		return nil, err
+	}
+
+	log.Printf("[main] unable to connect to configured storage, serving snapshot %s", file)
+	return newEnvRedisStorage(false)
+}
+
+// serve starts the HTTP server. The syncers are optional and only
+// used by the admin API.
+func serve(storage Storage, syncers []*Syncer, bus UpdateBus) error {
+	access, err := newEnvAccessConfig()
+	if err != nil {
+		return err
+	}
+
+	corsConfig, err := newEnvCORSConfig()
+	if err != nil {
+		return err
+	}
+
+	cache, err := newEnvResponseCache()
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := newEnvTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	aliases, err := newEnvAliases()
+	if err != nil {
+		return err
+	}
+
+	// While the storage is unreachable, the last known reports
+	// get served from a snapshot.
+	fallback := NewFallbackStorage(storage, os.Getenv("STORAGE_SNAPSHOT_FILE"))
+	go fallback.monitor(storageCheckInterval)
+	go fallback.listen(bus)
+	for _, syncer := range syncers {
+		syncer.Observe(fallback)
+	}
+
+	server := &server{
+		router:     mux.NewRouter(),
+		storage:    fallback,
+		syncers:    syncers,
+		adminToken: os.Getenv("ADMIN_TOKEN"),
+		aliases:    aliases,
+		hub:        newStreamHub(),
+		access:     access,
+		cache:      cache,
+		fallback:   fallback,
+	}
+	go server.hub.listen(bus)
+
+	// The search index gets rebuilt whenever reports change, no
+	// matter which instance synced them. The bus delivers this
+	// instance's updates as well.
+	server.search = newSearchIndex(fallback)
+	server.search.refresh()
+	go server.search.listen(bus)
+
+	// Reports synced by this instance purge the cache right away,
+	// reports synced elsewhere once they arrive over the bus.
+	if cache != nil {
+		go cache.listen(bus)
+		for _, syncer := range syncers {
+			syncer.Observe(cache)
+		}
+	}
+
+	if ss, ok := storage.(SubscriptionStorage); ok {
+		server.subscriptions = ss
+	}
+	if cs, ok := storage.(ChangeStorage); ok {
+		server.changes = cs
+	}
+	if hs, ok := storage.(HistoryStorage); ok {
+		server.history = hs
+	}
+	if ks, ok := storage.(APIKeyStorage); ok {
+		server.apiKeys = ks
+	}
+	if l, ok := storage.(RateLimiter); ok {
+		server.limiter = l
+	}
+
+	// The syncers only start once every observer is registered,
+	// so even the first sync reaches the fallback and the cache.
+	for _, syncer := range syncers {
+		go syncer.Run()
+	}
+
+	server.routes()
+	n := negroni.Classic()
+	n.Use(server.corsMiddleware(corsConfig))
+	n.UseHandler(server)
+
+	addr := ":8000"
+	if v, exists := os.LookupEnv("LISTEN_ADDR"); exists {
+		addr = v
+	}
+
+	// There is no WriteTimeout since it would cut off streams.
+	// The router limits the time of all other requests instead.
+	s := &http.Server{
+		Addr:        addr,
+		Handler:     n,
+		ReadTimeout: 10 * time.Second,
+	}
+
+	// Most deployments should terminate TLS at a reverse proxy,
+	// but the server can do it itself if it runs on its own.
+	if tlsConfig != nil {
+		return listenAndServeTLS(s, tlsConfig)
+	}
+
+	return s.ListenAndServe()
+}
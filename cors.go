@@ -0,0 +1,188 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/cors"
+	"github.com/urfave/negroni"
+)
+
+var (
+	defaultCORSOrigins = []string{"*"}
+	defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	defaultCORSHeaders = []string{"Origin", "Accept", "Content-Type", "X-Requested-With", apiKeyHeader}
+
+	// corsExposedHeaders can be read by browser clients in addition
+	// to the simple response headers.
+	corsExposedHeaders = []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"}
+)
+
+// corsConfig controls which websites can access the API from
+// the browser.
+type corsConfig struct {
+	// origins may contain "*" to allow every origin or a single
+	// wildcard like "https://*.example.com".
+	origins     []string
+	methods     []string
+	headers     []string
+	maxAge      int
+	credentials bool
+}
+
+// newEnvCORSConfig returns a CORS config configured via
+// environment variables. Without any configuration every origin
+// is allowed, same as before.
+func newEnvCORSConfig() (*corsConfig, error) {
+	c := &corsConfig{
+		origins: defaultCORSOrigins,
+		methods: defaultCORSMethods,
+		headers: defaultCORSHeaders,
+	}
+
+	if v, exists := os.LookupEnv("CORS_ALLOWED_ORIGINS"); exists {
+		c.origins = splitList(strings.ToLower(v))
+		for _, o := range c.origins {
+			if o != "*" && !validOrigin(o) {
+				return nil, errors.Errorf("invalid CORS_ALLOWED_ORIGINS entry %q", o)
+			}
+		}
+	}
+
+	if v, exists := os.LookupEnv("CORS_ALLOWED_METHODS"); exists {
+		c.methods = splitList(strings.ToUpper(v))
+	}
+
+	if v, exists := os.LookupEnv("CORS_ALLOWED_HEADERS"); exists {
+		c.headers = splitList(v)
+	}
+
+	if v, exists := os.LookupEnv("CORS_MAX_AGE"); exists {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid CORS_MAX_AGE %q", v)
+		}
+		c.maxAge = n
+	}
+
+	if v, exists := os.LookupEnv("CORS_ALLOW_CREDENTIALS"); exists {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.Errorf("invalid CORS_ALLOW_CREDENTIALS %q", v)
+		}
+		c.credentials = b
+	}
+
+	// Reflecting any origin together with credentials would let
+	// every website read responses on behalf of its visitors.
+	if c.credentials {
+		for _, o := range c.origins {
+			if o == "*" {
+				return nil, errors.New("CORS_ALLOW_CREDENTIALS requires CORS_ALLOWED_ORIGINS without \"*\"")
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// corsMiddleware returns the CORS middleware for the config. The
+// API key it looks up is reused when the request gets limited.
+func (s *server) corsMiddleware(c *corsConfig) negroni.Handler {
+	m := cors.New(cors.Options{
+		AllowOriginRequestFunc: s.allowOrigin(c),
+		AllowedMethods:         c.methods,
+		AllowedHeaders:         c.headers,
+		ExposedHeaders:         corsExposedHeaders,
+		MaxAge:                 c.maxAge,
+		AllowCredentials:       c.credentials,
+	})
+
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		m.ServeHTTP(w, withKeyLookup(r), next)
+	})
+}
+
+// allowOrigin checks the origin of a request. Requests with an
+// API key that has its own list of allowed origins are checked
+// against that list, all other requests against the configured
+// origins.
+func (s *server) allowOrigin(c *corsConfig) func(r *http.Request, origin string) bool {
+	return func(r *http.Request, origin string) bool {
+		origin = strings.ToLower(origin)
+
+		if key := requestAPIKey(r); key != "" && s.apiKeys != nil {
+			k, err := s.requestKey(r, key)
+			if err == nil && len(k.AllowedOrigins) > 0 {
+				return matchOrigin(k.AllowedOrigins, origin)
+			}
+		}
+
+		if matchOrigin(c.origins, origin) {
+			return true
+		}
+
+		// Browsers don't send the X-API-Key header with preflight
+		// requests, so we can't tell which key is going to be used.
+		// The actual request still gets checked against the key.
+		return s.apiKeys != nil && r.Method == http.MethodOptions && requestsHeader(r, apiKeyHeader)
+	}
+}
+
+// matchOrigin checks if the origin is in the list of allowed origins.
+func matchOrigin(allowed []string, origin string) bool {
+	for _, a := range allowed {
+		if a == "*" || a == origin {
+			return true
+		}
+
+		i := strings.IndexByte(a, '*')
+		if i < 0 {
+			continue
+		}
+
+		prefix, suffix := a[:i], a[i+1:]
+		if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validOrigin checks that o is a scheme and host without a path,
+// e.g. "https://example.com". The host may start with a wildcard.
+func validOrigin(o string) bool {
+	u, err := url.Parse(strings.Replace(o, "://*.", "://wildcard.", 1))
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
+
+// requestsHeader checks if a preflight request announces that
+// the actual request is going to send the header.
+func requestsHeader(r *http.Request, header string) bool {
+	for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if strings.EqualFold(strings.TrimSpace(h), header) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list and drops empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/urfave/negroni"
+)
+
+func TestNewEnvCORSConfig(t *testing.T) {
+	testCases := map[string]struct {
+		env      map[string]string
+		expected *corsConfig
+		err      bool
+	}{
+		"defaults": {
+			env: map[string]string{},
+			expected: &corsConfig{
+				origins: defaultCORSOrigins,
+				methods: defaultCORSMethods,
+				headers: defaultCORSHeaders,
+			},
+		},
+		"configured": {
+			env: map[string]string{
+				"CORS_ALLOWED_ORIGINS":   "https://Example.com, https://*.achoo.dev",
+				"CORS_ALLOWED_METHODS":   "get,delete",
+				"CORS_ALLOWED_HEADERS":   "X-API-Key",
+				"CORS_MAX_AGE":           "600",
+				"CORS_ALLOW_CREDENTIALS": "true",
+			},
+			expected: &corsConfig{
+				origins:     []string{"https://example.com", "https://*.achoo.dev"},
+				methods:     []string{"GET", "DELETE"},
+				headers:     []string{"X-API-Key"},
+				maxAge:      600,
+				credentials: true,
+			},
+		},
+		"origin with path": {
+			env: map[string]string{"CORS_ALLOWED_ORIGINS": "https://example.com/app"},
+			err: true,
+		},
+		"credentials with any origin": {
+			env: map[string]string{"CORS_ALLOW_CREDENTIALS": "true"},
+			err: true,
+		},
+		"negative max age": {
+			env: map[string]string{"CORS_MAX_AGE": "-1"},
+			err: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			c, err := newEnvCORSConfig()
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.expected, c, cmp.AllowUnexported(corsConfig{})); diff != "" {
+				t.Errorf("config differs (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	testCases := []struct {
+		allowed  []string
+		origin   string
+		expected bool
+	}{
+		{[]string{"*"}, "https://example.com", true},
+		{[]string{"https://example.com"}, "https://example.com", true},
+		{[]string{"https://example.com"}, "http://example.com", false},
+		{[]string{"https://*.example.com"}, "https://app.example.com", true},
+		{[]string{"https://*.example.com"}, "https://example.com", false},
+		{[]string{"https://*.example.com"}, "https://example.com.evil.org", false},
+		{nil, "https://example.com", false},
+	}
+
+	for _, tc := range testCases {
+		if actual := matchOrigin(tc.allowed, tc.origin); actual != tc.expected {
+			t.Errorf("matchOrigin(%v, %q): expected %v, got %v", tc.allowed, tc.origin, tc.expected, actual)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+
+	s := createServer()
+	s.storage = storage
+	s.apiKeys = storage
+	s.adminToken = "::token::"
+	handler := negroni.New(s.corsMiddleware(&corsConfig{
+		origins: []string{"https://achoo.dev"},
+		methods: defaultCORSMethods,
+		headers: defaultCORSHeaders,
+	}))
+	handler.UseHandler(s)
+
+	r := httptest.NewRequest("POST", "/admin/keys", bytes.NewBufferString(`{"name":"::client::","allowed_origins":["https://widget.example.com"]}`))
+	r.Header.Set("Authorization", "Bearer ::token::")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unable to create api key: %d %s", w.Code, w.Body)
+	}
+	var key APIKey
+	json.NewDecoder(w.Body).Decode(&key)
+
+	testCases := map[string]struct {
+		method   string
+		origin   string
+		header   http.Header
+		expected string
+	}{
+		"configured origin": {
+			method:   "GET",
+			origin:   "https://achoo.dev",
+			expected: "https://achoo.dev",
+		},
+		"unknown origin": {
+			method:   "GET",
+			origin:   "https://example.com",
+			expected: "",
+		},
+		"origin of the api key": {
+			method:   "GET",
+			origin:   "https://widget.example.com",
+			header:   http.Header{apiKeyHeader: []string{key.Key}},
+			expected: "https://widget.example.com",
+		},
+		"api key restricts origins": {
+			method:   "GET",
+			origin:   "https://achoo.dev",
+			header:   http.Header{apiKeyHeader: []string{key.Key}},
+			expected: "",
+		},
+		"preflight for a request with an api key": {
+			method: "OPTIONS",
+			origin: "https://widget.example.com",
+			header: http.Header{
+				"Access-Control-Request-Method":  []string{"GET"},
+				"Access-Control-Request-Headers": []string{"x-api-key"},
+			},
+			expected: "https://widget.example.com",
+		},
+		"preflight from unknown origin": {
+			method: "OPTIONS",
+			origin: "https://widget.example.com",
+			header: http.Header{
+				"Access-Control-Request-Method": []string{"GET"},
+			},
+			expected: "",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/regions", nil)
+			for k, v := range tc.header {
+				r.Header.Set(k, v[0])
+			}
+			r.Header.Set("Origin", tc.origin)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			if actual := w.Header().Get("Access-Control-Allow-Origin"); actual != tc.expected {
+				t.Errorf("expected allowed origin %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+// countingKeyStorage counts how often API keys get looked up.
+type countingKeyStorage struct {
+	APIKeyStorage
+	lookups int
+}
+
+func (c *countingKeyStorage) GetAPIKey(hash string) (*APIKey, error) {
+	c.lookups++
+	return c.APIKeyStorage.GetAPIKey(hash)
+}
+
+func TestCORSSharesAPIKeyLookup(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+
+	key := &APIKey{ID: "::id::", Key: "::key::", AllowedOrigins: []string{"https://widget.example.com"}}
+	storage.SaveAPIKey(key, hashAPIKey(key.Key))
+
+	keys := &countingKeyStorage{APIKeyStorage: storage}
+	s := createServer()
+	s.storage = storage
+	s.apiKeys = keys
+	s.access = &accessConfig{keyLimit: defaultKeyRateLimit}
+	s.limiter = storage
+
+	handler := negroni.New(s.corsMiddleware(&corsConfig{
+		origins: []string{"https://achoo.dev"},
+		methods: defaultCORSMethods,
+		headers: defaultCORSHeaders,
+	}))
+	handler.UseHandler(s)
+
+	r := httptest.NewRequest("GET", "/regions", nil)
+	r.Header.Set("Origin", "https://widget.example.com")
+	r.Header.Set(apiKeyHeader, key.Key)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://widget.example.com" || w.Header().Get("X-RateLimit-Limit") == "" {
+		t.Fatalf("expected request to be allowed and limited, got %d %v", w.Code, w.Header())
+	}
+
+	if keys.lookups != 1 {
+		t.Errorf("expected the api key to be looked up once, got %d lookups", keys.lookups)
+	}
+}
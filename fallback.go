@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultConnectTimeout = time.Minute
+	connectMinBackoff     = 500 * time.Millisecond
+	connectMaxBackoff     = 10 * time.Second
+
+	// storageCheckInterval is how often an unreachable storage
+	// gets checked, and the other way around.
+	storageCheckInterval = 5 * time.Second
+)
+
+// ErrStorageUnavailable is returned if the storage can't be reached
+// and there is no snapshot to fall back to.
+var ErrStorageUnavailable = errors.New("storage: unavailable")
+
+// pinger is implemented by storages which can check their
+// connection.
+type pinger interface {
+	Ping() error
+}
+
+// connectWithRetry calls connect until it succeeds or the timeout
+// passed. It only retries if the storage couldn't be reached.
+func connectWithRetry(connect func() (Storage, error), timeout time.Duration) (Storage, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := connectMinBackoff
+
+	for {
+		storage, err := connect()
+		if err != ErrCouldNotConnectToStorage || time.Now().Add(backoff).After(deadline) {
+			return storage, err
+		}
+
+		log.Printf("[storage] unable to connect, retrying in %s", backoff)
+		time.Sleep(backoff)
+
+		if backoff *= 2; backoff > connectMaxBackoff {
+			backoff = connectMaxBackoff
+		}
+	}
+}
+
+// FallbackStorage reads from the primary storage as long as it is
+// reachable. Otherwise it serves the last known reports from a
+// snapshot. The snapshot is kept in memory and optionally in a
+// file, so it survives restarts.
+type FallbackStorage struct {
+	Storage
+
+	// pinger is nil if the primary storage can't check its
+	// connection. In this case it is never considered down.
+	pinger pinger
+
+	// file is where the snapshot gets written to. If it is empty,
+	// the snapshot only lives in memory.
+	file string
+
+	mu        sync.RWMutex
+	snapshot  *dump
+	available bool
+}
+
+// NewFallbackStorage wraps the primary storage. An existing
+// snapshot file gets loaded, but is replaced by the current data
+// if the primary storage is reachable. Otherwise the snapshot is
+// served until monitor notices that the storage is back.
+func NewFallbackStorage(primary Storage, file string) *FallbackStorage {
+	f := &FallbackStorage{
+		Storage:   primary,
+		file:      file,
+		available: true,
+	}
+	f.pinger, _ = primary.(pinger)
+
+	if file != "" {
+		if err := f.load(); err != nil && !os.IsNotExist(errors.Cause(err)) {
+			log.Printf("[storage] unable to load snapshot: %q", err.Error())
+		}
+	}
+
+	if f.pinger != nil && f.pinger.Ping() != nil {
+		log.Printf("[storage] storage unavailable, serving snapshot")
+		f.available = false
+		return f
+	}
+	f.refresh()
+
+	return f
+}
+
+// Available returns whether the primary storage is reachable.
+func (f *FallbackStorage) Available() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.available
+}
+
+// HasSnapshot returns whether there is data to fall back to.
+func (f *FallbackStorage) HasSnapshot() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.snapshot != nil
+}
+
+func (f *FallbackStorage) setAvailable(available bool) {
+	f.mu.Lock()
+	changed := f.available != available
+	f.available = available
+	f.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if available {
+		log.Printf("[storage] connection restored")
+		f.refresh()
+	} else {
+		log.Printf("[storage] storage unavailable, serving snapshot")
+	}
+}
+
+// failed checks if err means that the primary storage is not
+// reachable. Not finding something is fine.
+func (f *FallbackStorage) failed(err error) bool {
+	if err == nil || err == ErrNotFound {
+		return false
+	}
+
+	log.Printf("[storage] falling back to snapshot: %q", err.Error())
+	if f.pinger != nil {
+		f.setAvailable(false)
+	}
+	return true
+}
+
+// monitor checks the connection of the primary storage until the
+// process exits. The client reconnects on its own, this only
+// decides whether to use the snapshot.
+func (f *FallbackStorage) monitor(interval time.Duration) {
+	if f.pinger == nil {
+		return
+	}
+
+	for range time.Tick(interval) {
+		f.setAvailable(f.pinger.Ping() == nil)
+	}
+}
+
+// ReportsSynced implements SyncObserver.
+func (f *FallbackStorage) ReportsSynced(updates []*ReportUpdate) {
+	if len(updates) > 0 {
+		f.refresh()
+	}
+}
+
+// listen refreshes the snapshot whenever another instance publishes
+// updated reports.
+func (f *FallbackStorage) listen(bus UpdateBus) {
+	ch, _ := bus.Subscribe()
+	for range ch {
+		f.refresh()
+	}
+}
+
+// refresh takes a new snapshot of the primary storage.
+func (f *FallbackStorage) refresh() {
+	var (
+		d   dump
+		err error
+	)
+	if d.Regions, err = f.Storage.AllRegions(); err == nil {
+		if d.Subregions, err = f.Storage.AllSubregions(); err == nil {
+			d.Reports, err = f.Storage.AllReports()
+		}
+	}
+	if err != nil {
+		log.Printf("[storage] unable to take snapshot: %q", err.Error())
+		return
+	}
+
+	// Don't replace a good snapshot with an empty storage, e.g.
+	// after redis lost its data.
+	if len(d.Reports) == 0 {
+		return
+	}
+
+	f.mu.Lock()
+	f.snapshot = &d
+	f.mu.Unlock()
+
+	if f.file != "" {
+		if err := f.save(&d); err != nil {
+			log.Printf("[storage] unable to write snapshot: %q", err.Error())
+		}
+	}
+}
+
+func (f *FallbackStorage) load() error {
+	data, err := ioutil.ReadFile(f.file)
+	if err != nil {
+		return err
+	}
+
+	var d dump
+	if err := json.Unmarshal(data, &d); err != nil {
+		return errors.Wrap(err, "invalid snapshot")
+	}
+
+	f.mu.Lock()
+	f.snapshot = &d
+	f.mu.Unlock()
+
+	return nil
+}
+
+// save writes the snapshot to a temporary file first, so a crash
+// never leaves a truncated snapshot behind.
+func (f *FallbackStorage) save(d *dump) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.file), ".snapshot-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.file)
+}
+
+func (f *FallbackStorage) current() (*dump, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.snapshot == nil {
+		return nil, ErrStorageUnavailable
+	}
+	return f.snapshot, nil
+}
+
+// AllRegions implements Storage.
+func (f *FallbackStorage) AllRegions() ([]string, error) {
+	if f.Available() {
+		rs, err := f.Storage.AllRegions()
+		if !f.failed(err) {
+			return rs, err
+		}
+	}
+
+	d, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+	return d.Regions, nil
+}
+
+// AllSubregions implements Storage.
+func (f *FallbackStorage) AllSubregions() ([]string, error) {
+	if f.Available() {
+		ss, err := f.Storage.AllSubregions()
+		if !f.failed(err) {
+			return ss, err
+		}
+	}
+
+	d, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+	return d.Subregions, nil
+}
+
+// AllReports implements Storage.
+func (f *FallbackStorage) AllReports() ([]*PollenReport, error) {
+	if f.Available() {
+		rs, err := f.Storage.AllReports()
+		if !f.failed(err) {
+			return rs, err
+		}
+	}
+
+	d, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+	return d.Reports, nil
+}
+
+// GetByRegion implements Storage.
+func (f *FallbackStorage) GetByRegion(region string) ([]*PollenReport, error) {
+	if f.Available() {
+		rs, err := f.Storage.GetByRegion(region)
+		if !f.failed(err) {
+			return rs, err
+		}
+	}
+
+	d, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []*PollenReport
+	for _, r := range d.Reports {
+		if normalizeString(r.Region) == normalizeString(region) {
+			rs = append(rs, r)
+		}
+	}
+	if len(rs) == 0 {
+		return nil, ErrNotFound
+	}
+	return rs, nil
+}
+
+// GetBySubregion implements Storage.
+func (f *FallbackStorage) GetBySubregion(subregion string) (*PollenReport, error) {
+	if f.Available() {
+		r, err := f.Storage.GetBySubregion(subregion)
+		if !f.failed(err) {
+			return r, err
+		}
+	}
+
+	d, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range d.Reports {
+		if r.Key() == normalizeString(subregion) {
+			return r, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetBySubregions implements Storage.
+func (f *FallbackStorage) GetBySubregions(subregions []string) ([]*PollenReport, error) {
+	if f.Available() {
+		rs, err := f.Storage.GetBySubregions(subregions)
+		if !f.failed(err) {
+			return rs, err
+		}
+	}
+
+	d, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]*PollenReport)
+	for _, r := range d.Reports {
+		byKey[r.Key()] = r
+	}
+
+	rs := make([]*PollenReport, len(subregions))
+	for i, subregion := range subregions {
+		rs[i] = byKey[normalizeString(subregion)]
+	}
+	return rs, nil
+}
+
+// Save implements Storage. Nothing can be saved while the primary
+// storage is unavailable.
+func (f *FallbackStorage) Save(r *PollenReport) error {
+	if !f.Available() {
+		return ErrStorageUnavailable
+	}
+
+	err := f.Storage.Save(r)
+	f.failed(err)
+	return err
+}
+
+// degraded returns whether responses are currently served from
+// the snapshot instead of the storage.
+func (s *server) degraded() bool {
+	return s.fallback != nil && !s.fallback.Available()
+}
+
+// warnDegraded marks responses served from the snapshot as stale.
+// Without a snapshot, there is nothing to serve.
+func (s *server) warnDegraded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" || !s.degraded() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.fallback.HasSnapshot() {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(storageCheckInterval)))
+			respond(w, http.StatusServiceUnavailable, &invalidRequestResponse{"Storage is unavailable"})
+			return
+		}
+
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// flakyStorage fails every call while it is down.
+type flakyStorage struct {
+	*RedisStorage
+	down bool
+}
+
+var errConnectionRefused = errors.New("::connection refused::")
+
+func (s *flakyStorage) Ping() error {
+	if s.down {
+		return errConnectionRefused
+	}
+	return nil
+}
+
+func (s *flakyStorage) AllRegions() ([]string, error) {
+	if s.down {
+		return nil, errConnectionRefused
+	}
+	return s.RedisStorage.AllRegions()
+}
+
+func (s *flakyStorage) AllReports() ([]*PollenReport, error) {
+	if s.down {
+		return nil, errConnectionRefused
+	}
+	return s.RedisStorage.AllReports()
+}
+
+func (s *flakyStorage) GetBySubregion(subregion string) (*PollenReport, error) {
+	if s.down {
+		return nil, errConnectionRefused
+	}
+	return s.RedisStorage.GetBySubregion(subregion)
+}
+
+func (s *flakyStorage) GetBySubregions(subregions []string) ([]*PollenReport, error) {
+	if s.down {
+		return nil, errConnectionRefused
+	}
+	return s.RedisStorage.GetBySubregions(subregions)
+}
+
+func TestFallbackStorage(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "snapshot.json")
+
+	primary := &flakyStorage{RedisStorage: newStorage(mr)}
+	f := NewFallbackStorage(primary, file)
+
+	s := createServer()
+	s.storage = f
+	s.fallback = f
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	if w := get("/pollen/subregion/subregion-aa"); w.Code != http.StatusOK || w.Header().Get("Warning") != "" {
+		t.Fatalf("expected fresh response, got %d %v", w.Code, w.Header())
+	}
+
+	primary.down = true
+
+	// The first failing request notices that the storage is down.
+	if w := get("/regions"); w.Code != http.StatusOK {
+		t.Errorf("expected regions from the snapshot, got %d %s", w.Code, w.Body)
+	}
+	if f.Available() {
+		t.Fatal("expected storage to be unavailable")
+	}
+
+	w := get("/pollen/subregion/subregion-aa")
+	if w.Code != http.StatusOK || w.Header().Get("Warning") == "" {
+		t.Errorf("expected stale response with a warning, got %d %v", w.Code, w.Header())
+	}
+	if w := get("/pollen/subregion/nope"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 from the snapshot, got %d", w.Code)
+	}
+
+	t.Run("snapshot survives restarts", func(t *testing.T) {
+		restarted := NewFallbackStorage(&flakyStorage{RedisStorage: primary.RedisStorage, down: true}, file)
+		restarted.setAvailable(false)
+
+		rs, err := restarted.AllReports()
+		if err != nil || len(rs) != 4 {
+			t.Errorf("expected 4 reports from the snapshot file, got %d %v", len(rs), err)
+		}
+	})
+
+	t.Run("without snapshot", func(t *testing.T) {
+		empty := NewFallbackStorage(&flakyStorage{RedisStorage: primary.RedisStorage, down: true}, "")
+		empty.setAvailable(false)
+		s.storage = empty
+		s.fallback = empty
+		defer func() {
+			s.storage = f
+			s.fallback = f
+		}()
+
+		w := get("/pollen")
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Errorf("expected 503, got %d %v", w.Code, w.Header())
+		}
+		if w := get("/ping"); w.Code != http.StatusOK {
+			t.Errorf("expected health check to work, got %d", w.Code)
+		}
+	})
+
+	primary.down = false
+	f.setAvailable(primary.Ping() == nil)
+
+	if w := get("/pollen/subregion/subregion-aa"); w.Code != http.StatusOK || w.Header().Get("Warning") != "" {
+		t.Errorf("expected fresh response after reconnecting, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	attempts := 0
+	connect := func() (Storage, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, ErrCouldNotConnectToStorage
+		}
+		return &inMemoryStorage{}, nil
+	}
+
+	storage, err := connectWithRetry(connect, 10*time.Second)
+	if err != nil || storage == nil {
+		t.Fatalf("expected to connect eventually, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+
+	attempts = 0
+	if _, err := connectWithRetry(connect, 0); err != ErrCouldNotConnectToStorage || attempts != 1 {
+		t.Errorf("expected to give up after the timeout, got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestStartWithoutStorage(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "snapshot.json")
+
+	// Take a snapshot while redis is still up.
+	NewFallbackStorage(newStorage(mr), file)
+	addr := mr.Addr()
+	mr.Close()
+
+	os.Setenv("REDIS_URL", "redis://"+addr)
+	os.Setenv("STORAGE_CONNECT_TIMEOUT", "0")
+	defer os.Unsetenv("REDIS_URL")
+	defer os.Unsetenv("STORAGE_CONNECT_TIMEOUT")
+
+	if _, err := connectServingStorage(); err != errStorageUnreachable {
+		t.Fatalf("expected to fail without a snapshot, got %v", err)
+	}
+
+	os.Setenv("STORAGE_SNAPSHOT_FILE", file)
+	defer os.Unsetenv("STORAGE_SNAPSHOT_FILE")
+
+	storage, err := connectServingStorage()
+	if err != nil {
+		t.Fatalf("expected to start with the snapshot, got %v", err)
+	}
+
+	f := NewFallbackStorage(storage, file)
+	if f.Available() {
+		t.Fatal("expected storage to be unavailable")
+	}
+	if rs, err := f.AllReports(); err != nil || len(rs) != 4 {
+		t.Errorf("expected 4 reports from the snapshot, got %d %v", len(rs), err)
+	}
+
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	f.setAvailable(f.pinger.Ping() == nil)
+
+	if !f.Available() {
+		t.Error("expected storage to be reconnected")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+var formatContentTypes = map[string]string{
+	formatJSON:   "application/json",
+	formatCSV:    "text/csv; charset=utf-8",
+	formatNDJSON: "application/x-ndjson",
+}
+
+// acceptedMediaTypes maps the media types clients may ask for
+// in the Accept header to our formats.
+var acceptedMediaTypes = map[string]string{
+	"*/*":                  formatJSON,
+	"application/*":        formatJSON,
+	"application/json":     formatJSON,
+	"text/csv":             formatCSV,
+	"application/x-ndjson": formatNDJSON,
+	"application/ndjson":   formatNDJSON,
+}
+
+// exportable is implemented by response data which can be
+// rendered as CSV or NDJSON as well as plain JSON.
+type exportable interface {
+	csvHeader() []string
+	csvRows() [][]string
+	items() []interface{}
+	// supports checks if the data can be rendered in the format.
+	supports(format string) bool
+}
+
+// negotiateFormat picks the response format. The format query
+// parameter takes precedence over the Accept header, whose media
+// types are tried from the highest to the lowest quality. Types
+// with a quality of zero are never used. It returns false if an
+// unsupported format was explicitly requested.
+func negotiateFormat(r *http.Request) (string, bool) {
+	if f := strings.ToLower(r.URL.Query().Get("format")); f != "" {
+		_, ok := formatContentTypes[f]
+		return f, ok
+	}
+
+	type accepted struct {
+		format  string
+		quality float64
+	}
+
+	var formats []accepted
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		f, ok := acceptedMediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil || quality <= 0 || quality > 1 {
+				continue
+			}
+		}
+
+		formats = append(formats, accepted{f, quality})
+	}
+
+	// Types with the same quality keep the order they were
+	// listed in.
+	sort.SliceStable(formats, func(i, j int) bool {
+		return formats[i].quality > formats[j].quality
+	})
+
+	if len(formats) > 0 {
+		return formats[0].format, true
+	}
+
+	return formatJSON, true
+}
+
+// respondExport writes data in the format requested by the
+// client. For JSON, jsonData gets written instead, so single
+// objects don't get turned into lists.
+func respondExport(w http.ResponseWriter, r *http.Request, status int, data exportable, jsonData interface{}) {
+	w.Header().Add("Vary", "Accept")
+
+	format, ok := negotiateFormat(r)
+	if !ok {
+		respond(w, http.StatusBadRequest, &invalidRequestResponse{"format has to be one of json, csv, ndjson"})
+		return
+	}
+	if !data.supports(format) {
+		respond(w, http.StatusBadRequest, &invalidRequestResponse{"This response is not available as " + format})
+		return
+	}
+
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", formatContentTypes[formatCSV])
+		w.WriteHeader(status)
+
+		cw := csv.NewWriter(w)
+		cw.Write(data.csvHeader())
+		cw.WriteAll(data.csvRows())
+		if err := cw.Error(); err != nil {
+			log.Printf("[routes] unable to write csv: %q", err.Error())
+		}
+	case formatNDJSON:
+		w.Header().Set("Content-Type", formatContentTypes[formatNDJSON])
+		w.WriteHeader(status)
+
+		enc := json.NewEncoder(w)
+		for _, item := range data.items() {
+			if err := enc.Encode(item); err != nil {
+				log.Printf("[routes] unable to write ndjson: %q", err.Error())
+				return
+			}
+		}
+	default:
+		respond(w, status, jsonData)
+	}
+}
+
+// reportList renders one CSV row per subregion, species and day.
+type reportList []*PollenReport
+
+func (l reportList) csvHeader() []string {
+	return []string{"source", "region", "sub_region", "last_update", "species", "name", "day", "date", "severity", "description"}
+}
+
+func (l reportList) csvRows() [][]string {
+	var rows [][]string
+	for _, r := range l {
+		for _, p := range r.Pollen {
+			for _, d := range p.days() {
+				if d.report == nil {
+					continue
+				}
+
+				rows = append(rows, []string{
+					r.source(),
+					r.Region,
+					r.SubRegion,
+					r.LastUpdate.Format(time.RFC3339),
+					p.Slug,
+					p.Name,
+					d.name,
+					r.Date().AddDate(0, 0, dayOffset(d.name)).Format("2006-01-02"),
+					d.report.Severity,
+					d.report.Description,
+				})
+			}
+		}
+	}
+	return rows
+}
+
+func (l reportList) items() []interface{} {
+	items := make([]interface{}, len(l))
+	for i, r := range l {
+		items[i] = r
+	}
+	return items
+}
+
+func (l reportList) supports(format string) bool {
+	return true
+}
+
+// byDayList renders reports grouped by day. There is no CSV
+// version, since every CSV row contains the day anyway.
+type byDayList struct {
+	reportList
+	view *reportView
+}
+
+func (l byDayList) items() []interface{} {
+	items := make([]interface{}, len(l.reportList))
+	for i, r := range l.reportList {
+		items[i] = l.view.render(r)
+	}
+	return items
+}
+
+func (l byDayList) supports(format string) bool {
+	return format != formatCSV
+}
+
+// changeList renders one CSV row per change.
+type changeList []*Change
+
+func (l changeList) csvHeader() []string {
+	return []string{"source", "region", "sub_region", "species", "name", "day", "from", "to", "delta", "detected_at"}
+}
+
+func (l changeList) csvRows() [][]string {
+	rows := make([][]string, len(l))
+	for i, c := range l {
+		rows[i] = []string{
+			c.report().source(),
+			c.Region,
+			c.SubRegion,
+			c.Species,
+			c.Name,
+			c.Day,
+			c.From,
+			c.To,
+			strconv.FormatFloat(c.Delta, 'f', -1, 64),
+			c.DetectedAt.Format(time.RFC3339),
+		}
+	}
+	return rows
+}
+
+func (l changeList) supports(format string) bool {
+	return true
+}
+
+func (l changeList) items() []interface{} {
+	items := make([]interface{}, len(l))
+	for i, c := range l {
+		items[i] = c
+	}
+	return items
+}
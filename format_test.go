@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		description string
+		query       string
+		accept      string
+		want        string
+		ok          bool
+	}{
+		{"defaults to json", "", "", formatJSON, true},
+		{"browsers get json", "", "text/html,application/xhtml+xml,*/*;q=0.8", formatJSON, true},
+		{"csv via accept header", "", "text/csv", formatCSV, true},
+		{"ndjson via accept header", "", "application/x-ndjson", formatNDJSON, true},
+		{"highest quality wins", "", "text/csv;q=0.5, application/x-ndjson;q=0.9", formatNDJSON, true},
+		{"same quality keeps order", "", "text/csv;q=0.5, application/x-ndjson;q=0.5", formatCSV, true},
+		{"quality of zero is never used", "", "text/csv;q=0, application/json", formatJSON, true},
+		{"only unacceptable types", "", "text/csv;q=0", formatJSON, true},
+		{"wildcard with higher quality", "", "text/csv;q=0.5, */*", formatJSON, true},
+		{"invalid quality is ignored", "", "text/csv;q=abc, application/x-ndjson", formatNDJSON, true},
+		{"query takes precedence", "?format=ndjson", "text/csv", formatNDJSON, true},
+		{"query is case insensitive", "?format=CSV", "", formatCSV, true},
+		{"unsupported format", "?format=xml", "", "xml", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/pollen"+tc.query, nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+
+			got, ok := negotiateFormat(r)
+			if got != tc.want || ok != tc.ok {
+				t.Errorf("want (%q, %v), got (%q, %v)", tc.want, tc.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	s := createServer()
+	s.storage = newStorage(mr)
+
+	t.Run("csv", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/pollen/region/region-a?format=csv", nil))
+
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != formatContentTypes[formatCSV] {
+			t.Fatalf("expected csv response, got %d %q", w.Code, w.Header().Get("Content-Type"))
+		}
+
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Header plus one row for today's Roggen forecast of
+		// both subregions.
+		if len(rows) != 3 {
+			t.Fatalf("expected 3 rows, got %q", rows)
+		}
+
+		if !cmp.Equal(rows[0], reportList{}.csvHeader()) {
+			t.Errorf("unexpected header %q", rows[0])
+		}
+
+		if rows[1][0] != sourceDWD || rows[1][4] != "roggen" || rows[1][5] != "Roggen" || rows[1][6] != dayToday || rows[1][8] != "2" {
+			t.Errorf("unexpected row %q", rows[1])
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/pollen", nil)
+		r.Header.Set("Accept", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if len(lines) != 4 {
+			t.Errorf("expected one line per report, got %q", lines)
+		}
+	})
+
+	t.Run("ndjson by day", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/pollen/region/region-a?format=ndjson&view=by_day", nil))
+
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if w.Code != http.StatusOK || len(lines) != 2 || !strings.Contains(lines[0], `"days":`) {
+			t.Errorf("expected one report per line grouped by day, got %d %q", w.Code, lines)
+		}
+	})
+
+	t.Run("csv by day", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/pollen?format=csv&view=by_day", nil))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected csv to be unavailable for the by_day view, got %d", w.Code)
+		}
+	})
+
+	t.Run("single report as json", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/pollen/subregion/subregion_aa", nil))
+
+		if !strings.HasPrefix(w.Body.String(), "{") {
+			t.Errorf("expected a single object, got %s", w.Body)
+		}
+	})
+}
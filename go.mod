@@ -5,6 +5,7 @@ go 1.14
 require (
 	github.com/alicebob/gopher-json v0.0.0-20180125190556-5a6b3ba71ee6 // indirect
 	github.com/alicebob/miniredis v2.5.0+incompatible
+	github.com/andybalholm/brotli v1.0.2
 	github.com/go-redis/redis/v7 v7.2.0
 	github.com/gomodule/redigo v1.8.1 // indirect
 	github.com/google/go-cmp v0.4.0
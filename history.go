@@ -0,0 +1,278 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	// historyRetention is how long previous forecasts are kept
+	// around. Two years are enough to tell how seasons usually go.
+	historyRetention = 2 * 365 * 24 * time.Hour
+
+	// trendWindow is the number of days forecasts get compared
+	// with what actually happened to rate their accuracy.
+	trendWindow = 14
+
+	directionRising  = "rising"
+	directionFalling = "falling"
+	directionSteady  = "steady"
+)
+
+// HistoryStorage defines a type that keeps the forecasts of
+// previous days. Only the last forecast of every day is kept.
+type HistoryStorage interface {
+	SaveHistory(r *PollenReport) error
+	// History returns the forecasts for the subregion published
+	// since the provided day, oldest first.
+	History(subregion string, since time.Time) ([]*PollenReport, error)
+}
+
+// historyRecorder adds the reports of every sync to the history.
+type historyRecorder struct {
+	storage HistoryStorage
+}
+
+// ReportsSynced implements SyncObserver.
+func (h *historyRecorder) ReportsSynced(updates []*ReportUpdate) {
+	for _, u := range updates {
+		if err := h.storage.SaveHistory(u.Current); err != nil {
+			log.Printf("[history] unable to save forecast for %q: %q", u.Current.Key(), err.Error())
+		}
+	}
+}
+
+// trend compares today's forecast with the ones published on the
+// previous days and tells where the next days are heading.
+type trend struct {
+	Source     string          `json:"source"`
+	Region     string          `json:"region"`
+	SubRegion  string          `json:"sub_region"`
+	Date       string          `json:"date"`
+	LastUpdate time.Time       `json:"last_update"`
+	Pollen     []*speciesTrend `json:"pollen"`
+}
+
+type speciesTrend struct {
+	Name      string `json:"name"`
+	Slug      string `json:"slug"`
+	LatinName string `json:"latin_name"`
+	Today     string `json:"today"`
+
+	// Forecasts contains what was forecast for today on the
+	// previous two days, most recent first.
+	Forecasts []*pastForecast `json:"forecasts"`
+
+	// Accuracy is between 0 and 1 and describes how close the
+	// forecasts of the last two weeks came to the actual values.
+	// It is nil if there is no history to compare with.
+	Accuracy *float64 `json:"accuracy"`
+	Samples  int      `json:"samples"`
+
+	// Direction compares the mean of the next two days with
+	// today.
+	Direction string        `json:"direction"`
+	Outlook   []*outlookDay `json:"outlook"`
+}
+
+type pastForecast struct {
+	IssuedOn string `json:"issued_on"`
+	Severity string `json:"severity"`
+	// Error is how far the forecast was off. Positive values
+	// mean the forecast was too high.
+	Error float64 `json:"error"`
+}
+
+type outlookDay struct {
+	Day       string  `json:"day"`
+	Date      string  `json:"date"`
+	Severity  string  `json:"severity"`
+	Delta     float64 `json:"delta"`
+	Direction string  `json:"direction"`
+}
+
+// computeTrend builds the trend of the current report. The history
+// may contain an older version of the current report, in which
+// case the current one wins.
+func computeTrend(current *PollenReport, history []*PollenReport) *trend {
+	date := current.Date()
+
+	byDate := make(map[string]*PollenReport)
+	for _, h := range history {
+		byDate[formatDate(h.Date())] = h
+	}
+	byDate[formatDate(date)] = current
+
+	t := &trend{
+		Source:     current.source(),
+		Region:     current.Region,
+		SubRegion:  current.SubRegion,
+		Date:       formatDate(date),
+		LastUpdate: current.LastUpdate,
+		Pollen:     []*speciesTrend{},
+	}
+
+	for _, p := range current.Pollen {
+		if p.Today == nil {
+			continue
+		}
+		today, ok := severityLevel(p.Today.Severity)
+		if !ok {
+			continue
+		}
+
+		st := &speciesTrend{
+			Name:      p.Name,
+			Slug:      p.Slug,
+			LatinName: p.LatinName,
+			Today:     p.Today.Severity,
+			Forecasts: []*pastForecast{},
+			Outlook:   []*outlookDay{},
+		}
+
+		for _, ahead := range []string{dayTomorrow, dayDayAfterTomorrow} {
+			issued := date.AddDate(0, 0, -dayOffset(ahead))
+			if f := forecastFor(byDate[formatDate(issued)], p.Slug, ahead); f != nil {
+				if level, ok := severityLevel(f.Severity); ok {
+					st.Forecasts = append(st.Forecasts, &pastForecast{
+						IssuedOn: formatDate(issued),
+						Severity: f.Severity,
+						Error:    level - today,
+					})
+				}
+			}
+		}
+
+		st.Accuracy, st.Samples = forecastAccuracy(byDate, p.Slug, date)
+
+		var sum float64
+		var n int
+		for _, d := range p.days()[1:] {
+			if d.report == nil {
+				continue
+			}
+			level, ok := severityLevel(d.report.Severity)
+			if !ok {
+				continue
+			}
+
+			st.Outlook = append(st.Outlook, &outlookDay{
+				Day:       d.name,
+				Date:      formatDate(date.AddDate(0, 0, dayOffset(d.name))),
+				Severity:  d.report.Severity,
+				Delta:     level - today,
+				Direction: direction(level - today),
+			})
+			sum += level
+			n++
+		}
+
+		st.Direction = directionSteady
+		if n > 0 {
+			st.Direction = direction(sum/float64(n) - today)
+		}
+
+		t.Pollen = append(t.Pollen, st)
+	}
+
+	return t
+}
+
+// forecastAccuracy compares every forecast of the trend window
+// with the value published on the day it was made for.
+func forecastAccuracy(byDate map[string]*PollenReport, slug string, until time.Time) (*float64, int) {
+	var sum float64
+	var samples int
+
+	for i := 0; i < trendWindow; i++ {
+		target := until.AddDate(0, 0, -i)
+		actual := forecastFor(byDate[formatDate(target)], slug, dayToday)
+		if actual == nil {
+			continue
+		}
+		actualLevel, ok := severityLevel(actual.Severity)
+		if !ok {
+			continue
+		}
+
+		for _, ahead := range []string{dayTomorrow, dayDayAfterTomorrow} {
+			issued := target.AddDate(0, 0, -dayOffset(ahead))
+			f := forecastFor(byDate[formatDate(issued)], slug, ahead)
+			if f == nil {
+				continue
+			}
+			level, ok := severityLevel(f.Severity)
+			if !ok {
+				continue
+			}
+
+			sum += 1 - math.Abs(level-actualLevel)/3
+			samples++
+		}
+	}
+
+	if samples == 0 {
+		return nil, 0
+	}
+
+	accuracy := math.Round(sum/float64(samples)*100) / 100
+	return &accuracy, samples
+}
+
+// forecastFor returns the report's forecast of the species for
+// the day. The report may be nil.
+func forecastFor(r *PollenReport, slug, day string) *pollenDayReport {
+	p := r.species(slug)
+	if p == nil {
+		return nil
+	}
+	return p.day(day)
+}
+
+func direction(delta float64) string {
+	switch {
+	case delta > 0:
+		return directionRising
+	case delta < 0:
+		return directionFalling
+	}
+	return directionSteady
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func (s *server) handleGetTrend() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.history == nil {
+			respond(w, http.StatusServiceUnavailable, &invalidRequestResponse{"Forecast history is not supported"})
+			return
+		}
+
+		current, suggestions, err := s.lookupSubregion(subregionParam(r))
+		if err != nil {
+			if err == ErrNotFound {
+				respondNotFound(w, suggestions)
+				return
+			}
+
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		// The oldest day of the window needs the forecasts of
+		// the two days before it.
+		since := current.Date().AddDate(0, 0, -trendWindow-2)
+		history, err := s.history.History(current.Key(), since)
+		if err != nil {
+			log.Printf("[routes] unable to load history: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		respond(w, http.StatusOK, computeTrend(current, history))
+	}
+}
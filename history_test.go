@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// forecast returns a report for subregion-aa published on the day
+// with birke forecast for the next three days.
+func forecast(day int, today, tomorrow, dayAfter string) *PollenReport {
+	return &PollenReport{
+		Region:     "region-a",
+		SubRegion:  "subregion-aa",
+		LastUpdate: time.Date(2020, 4, day, 11, 0, 0, 0, dwdLocation),
+		Pollen: []*pollen{
+			{
+				Name:             "Birke",
+				Slug:             "birke",
+				Today:            &pollenDayReport{today, severityMap[today]},
+				Tomorrow:         &pollenDayReport{tomorrow, severityMap[tomorrow]},
+				DayAfterTomorrow: &pollenDayReport{dayAfter, severityMap[dayAfter]},
+			},
+		},
+	}
+}
+
+func TestComputeTrend(t *testing.T) {
+	current := forecast(10, "2", "2-3", "3")
+	history := []*PollenReport{
+		forecast(8, "1", "2", "2"),
+		forecast(9, "1", "2", "2"),
+	}
+
+	got := computeTrend(current, history)
+	accuracy := 0.89
+
+	want := &trend{
+		Source:     sourceDWD,
+		Region:     "region-a",
+		SubRegion:  "subregion-aa",
+		Date:       "2020-04-10",
+		LastUpdate: current.LastUpdate,
+		Pollen: []*speciesTrend{
+			{
+				Name:  "Birke",
+				Slug:  "birke",
+				Today: "2",
+				Forecasts: []*pastForecast{
+					{IssuedOn: "2020-04-09", Severity: "2", Error: 0},
+					{IssuedOn: "2020-04-08", Severity: "2", Error: 0},
+				},
+				// 8th → 9th was off by one, the other two were right.
+				Accuracy:  &accuracy,
+				Samples:   3,
+				Direction: directionRising,
+				Outlook: []*outlookDay{
+					{Day: dayTomorrow, Date: "2020-04-11", Severity: "2-3", Delta: 0.5, Direction: directionRising},
+					{Day: dayDayAfterTomorrow, Date: "2020-04-12", Severity: "3", Delta: 1, Direction: directionRising},
+				},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+
+	t.Run("without history", func(t *testing.T) {
+		got := computeTrend(forecast(10, "2", "1", "1"), nil)
+
+		st := got.Pollen[0]
+		if st.Accuracy != nil || st.Samples != 0 || len(st.Forecasts) != 0 {
+			t.Errorf("expected no accuracy without history, got %+v", st)
+		}
+		if st.Direction != directionFalling {
+			t.Errorf("expected falling, got %q", st.Direction)
+		}
+	})
+}
+
+func TestHistoryStorage(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	s := newStorage(mr)
+
+	// The second forecast of a day replaces the first one.
+	for _, r := range []*PollenReport{
+		forecast(8, "1", "1", "1"),
+		forecast(9, "1", "1", "1"),
+		forecast(9, "2", "2", "2"),
+	} {
+		if err := s.SaveHistory(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := s.History("subregion-aa", time.Date(2020, 4, 1, 0, 0, 0, 0, dwdLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 forecasts, got %d", len(history))
+	}
+	if history[1].Pollen[0].Today.Severity != "2" {
+		t.Errorf("expected the latest forecast of the day, got %+v", history[1].Pollen[0].Today)
+	}
+
+	history, err = s.History("subregion-aa", time.Date(2020, 4, 9, 0, 0, 0, 0, dwdLocation))
+	if err != nil || len(history) != 1 {
+		t.Errorf("expected 1 forecast since the 9th, got %d %v", len(history), err)
+	}
+}
+
+func TestHistoryStorageConcurrentSaves(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	s := newStorage(mr)
+
+	// Every syncer replaces the forecast of the same day, only
+	// one of them may remain.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		r := forecast(9, "1", "1", "1")
+		r.LastUpdate = r.LastUpdate.Add(time.Duration(i) * time.Minute)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.SaveHistory(r); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	history, err := s.History("subregion-aa", time.Date(2020, 4, 1, 0, 0, 0, 0, dwdLocation))
+	if err != nil || len(history) != 1 {
+		t.Errorf("expected a single forecast for the day, got %d %v", len(history), err)
+	}
+}
+
+func TestTrendEndpoint(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+
+	current := forecast(10, "2", "1", "1")
+	for _, r := range []*PollenReport{forecast(9, "1", "2", "2"), current} {
+		if err := storage.SaveHistory(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Save(current); err != nil {
+		t.Fatal(err)
+	}
+
+	s := createServer()
+	s.storage = storage
+
+	r := httptest.NewRequest("GET", "/pollen/subregion/subregion-aa/trend", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without history, got %d", w.Code)
+	}
+
+	s.history = storage
+
+	testCases := []struct {
+		url    string
+		status int
+	}{
+		{"/pollen/subregion/subregion-aa/trend", http.StatusOK},
+		{"/pollen/subregion/nope/trend", http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		r := httptest.NewRequest("GET", tc.url, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.url, tc.status, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/pollen/subregion/subregion-aa/trend", nil))
+
+	var got trend
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Pollen) != 1 || len(got.Pollen[0].Forecasts) != 1 || got.Pollen[0].Direction != directionFalling {
+		t.Errorf("unexpected trend %+v", got.Pollen[0])
+	}
+}
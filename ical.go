@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultCalendarSeverity is the minimum severity for which the
+// calendar feed contains events if none was requested.
+const defaultCalendarSeverity = "2"
+
+var icalEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\n", `\n`,
+)
+
+func (s *server) handleGetCalendarFeed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		minSeverity := q.Get("min_severity")
+		if minSeverity == "" {
+			minSeverity = defaultCalendarSeverity
+		}
+		min, ok := severityLevel(minSeverity)
+		if !ok {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{"min_severity has to be one of 0, 0-1, 1, 1-2, 2, 2-3, 3"})
+			return
+		}
+
+		species, msg := parseSpeciesList(q.Get("types"))
+		if msg != "" {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{msg})
+			return
+		}
+
+		report, suggestions, err := s.lookupSubregion(subregionParam(r))
+		if err != nil {
+			if err == ErrNotFound {
+				respondNotFound(w, suggestions)
+				return
+			}
+
+			log.Printf("[routes] unable to load report: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(calendarFeed(report, species, min, time.Now())))
+	}
+}
+
+// parseSpeciesList parses a comma separated list of species
+// slugs. If the list contains unknown species, it returns a
+// message explaining which.
+func parseSpeciesList(v string) ([]string, string) {
+	var species []string
+	for _, slug := range strings.Split(v, ",") {
+		if strings.TrimSpace(slug) == "" {
+			continue
+		}
+
+		sp, ok := lookupSpeciesBySlug(slug)
+		if !ok {
+			return nil, "Unknown species " + strings.TrimSpace(slug)
+		}
+		species = append(species, sp.Slug)
+	}
+
+	return species, ""
+}
+
+// calendarFeed renders an iCalendar feed containing an all-day
+// event for every day on which at least one of the species
+// reaches the minimum severity. If species is empty, all
+// species are considered.
+func calendarFeed(r *PollenReport, species []string, min float64, now time.Time) string {
+	sub := &Subscription{Species: species}
+
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		b.WriteString(foldICalLine(fmt.Sprintf(format, args...)))
+		b.WriteString("\r\n")
+	}
+
+	name := r.SubRegion
+	if name == "" {
+		name = r.Region
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//achoo.dev//Pollenflug//DE")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:%s", escapeICal("Pollenflug "+name))
+	line("X-PUBLISHED-TTL:PT1H")
+
+	for _, day := range []string{dayToday, dayTomorrow, dayDayAfterTomorrow} {
+		var summary, description []string
+		for _, p := range r.Pollen {
+			if !sub.includesSpecies(p.Slug) {
+				continue
+			}
+
+			d := p.day(day)
+			if d == nil {
+				continue
+			}
+
+			if level, ok := severityLevel(d.Severity); !ok || level < min {
+				continue
+			}
+
+			summary = append(summary, p.Name)
+			description = append(description, fmt.Sprintf("%s: %s (%s)", p.Name, d.Description, d.Severity))
+		}
+
+		if len(summary) == 0 {
+			continue
+		}
+
+		date := r.Date().AddDate(0, 0, dayOffset(day))
+
+		line("BEGIN:VEVENT")
+		line("UID:%s-%s@achoo.dev", date.Format("20060102"), slugify(r.Key()))
+		line("DTSTAMP:%s", now.UTC().Format("20060102T150405Z"))
+		line("DTSTART;VALUE=DATE:%s", date.Format("20060102"))
+		line("DTEND;VALUE=DATE:%s", date.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:%s", escapeICal("Pollenflug: "+strings.Join(summary, ", ")))
+		line("DESCRIPTION:%s", escapeICal(strings.Join(description, "\n")))
+		line("TRANSP:TRANSPARENT")
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+
+	return b.String()
+}
+
+func escapeICal(s string) string {
+	return icalEscaper.Replace(s)
+}
+
+// foldICalLine splits lines longer than 75 octets as required
+// by RFC 5545, making sure not to split multi-byte characters.
+func foldICalLine(s string) string {
+	if len(s) <= 75 {
+		return s
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		size := len(string(r))
+		if n+size > 75 {
+			b.WriteString("\r\n ")
+			// The leading space counts towards the next line.
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+
+	return b.String()
+}
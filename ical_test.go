@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendarFeed(t *testing.T) {
+	r := &PollenReport{
+		Region:     "region-a",
+		SubRegion:  "subregion-aa",
+		LastUpdate: time.Date(2020, 3, 31, 11, 0, 0, 0, dwdLocation),
+		Pollen: []*pollen{
+			{
+				Name:             "Birke",
+				Slug:             "birke",
+				Today:            &pollenDayReport{"2", "mittlere Belastung"},
+				Tomorrow:         &pollenDayReport{"1", "geringe Belastung"},
+				DayAfterTomorrow: &pollenDayReport{"1", "geringe Belastung"},
+			},
+			{
+				Name:             "Gräser",
+				Slug:             "graeser",
+				Today:            &pollenDayReport{"0", "keine Belastung"},
+				Tomorrow:         &pollenDayReport{"1", "geringe Belastung"},
+				DayAfterTomorrow: &pollenDayReport{"3", "hohe Belastung"},
+			},
+		},
+	}
+	now := time.Date(2020, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	want := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//achoo.dev//Pollenflug//DE",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Pollenflug subregion-aa",
+		"X-PUBLISHED-TTL:PT1H",
+		"BEGIN:VEVENT",
+		"UID:20200331-subregion-aa@achoo.dev",
+		"DTSTAMP:20200331T120000Z",
+		"DTSTART;VALUE=DATE:20200331",
+		"DTEND;VALUE=DATE:20200401",
+		"SUMMARY:Pollenflug: Birke",
+		"DESCRIPTION:Birke: mittlere Belastung (2)",
+		"TRANSP:TRANSPARENT",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:20200402-subregion-aa@achoo.dev",
+		"DTSTAMP:20200331T120000Z",
+		"DTSTART;VALUE=DATE:20200402",
+		"DTEND;VALUE=DATE:20200403",
+		"SUMMARY:Pollenflug: Gräser",
+		"DESCRIPTION:Gräser: hohe Belastung (3)",
+		"TRANSP:TRANSPARENT",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+
+	if got := calendarFeed(r, nil, 2, now); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+
+	got := calendarFeed(r, []string{"graeser"}, 2, now)
+	if strings.Contains(got, "Birke") || !strings.Contains(got, "Gräser") {
+		t.Errorf("expected only events for Gräser, got\n%s", got)
+	}
+
+	t.Run("without last update", func(t *testing.T) {
+		r.LastUpdate = time.Time{}
+
+		got := calendarFeed(r, nil, 2, now)
+		if today := time.Now().In(dwdLocation).Format("20060102"); !strings.Contains(got, "DTSTART;VALUE=DATE:"+today) {
+			t.Errorf("expected events to start today, got\n%s", got)
+		}
+	})
+}
+
+func TestFoldICalLine(t *testing.T) {
+	line := "DESCRIPTION:" + strings.Repeat("ä", 60)
+
+	got := foldICalLine(line)
+	for _, l := range strings.Split(got, "\r\n") {
+		if len(l) > 75 {
+			t.Errorf("line longer than 75 octets: %q", l)
+		}
+	}
+
+	if unfolded := strings.Replace(got, "\r\n ", "", -1); unfolded != line {
+		t.Errorf("folding changed the content: %q", unfolded)
+	}
+}
+
+func TestCalendarFeedValidatesParameters(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	s := createServer()
+	s.storage = newStorage(mr)
+
+	tests := []struct {
+		description string
+		url         string
+		want        int
+	}{
+		{"existing subregion", "/pollen/subregion/subregion_aa/calendar.ics?types=roggen", http.StatusOK},
+		{"unknown subregion", "/pollen/subregion/nope/calendar.ics", http.StatusNotFound},
+		{"unknown species", "/pollen/subregion/subregion_aa/calendar.ics?types=kaktus", http.StatusBadRequest},
+		{"invalid severity", "/pollen/subregion/subregion_aa/calendar.ics?min_severity=9", http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+
+			if w.Code != tc.want {
+				t.Errorf("wanted status %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+}
@@ -1,51 +1,52 @@
 package main
 
 import (
+	"fmt"
 	"log"
-	"net/http"
-	"time"
+	"os"
 
-	"github.com/gorilla/mux"
-	"github.com/rs/cors"
-	"github.com/urfave/negroni"
+	"github.com/pkg/errors"
 )
 
+const usage = `Usage: pollen-api [command] [arguments]
+
+Commands:
+  run           Start the API server and the sync daemon (default)
+  serve         Start the API server without syncing
+  sync          Fetch fresh data from every source once and exit
+                [-name source] [-source url|file|dir] [-replay]
+  dump [-o f]   Write all stored data as JSON to stdout or a file
+  import <file> Load a file in the DWD opendata format into the storage
+`
+
 func main() {
-	if err := run(); err != nil {
+	if err := run(os.Args[1:]); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run() error {
-	storage, err := NewEnvStorage()
-	if err != nil {
-		if err == ErrCouldNotConnectToStorage {
-			log.Fatal("[main] unable to connect to configured storage")
-		}
-		log.Fatal(err.Error())
-	}
-
-	syncer := NewSyncer(storage, 1*time.Hour)
-	go syncer.Run()
-
-	server := &server{
-		router:  mux.NewRouter(),
-		storage: storage,
+func run(args []string) error {
+	cmd := "run"
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
 	}
 
-	server.routes()
-	n := negroni.Classic()
-	n.Use(cors.Default())
-	n.UseHandler(server)
-
-	s := &http.Server{
-		Addr:         ":8000",
-		Handler:      n,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+	switch cmd {
+	case "run":
+		return runCommand(args)
+	case "serve":
+		return serveCommand(args)
+	case "sync":
+		return syncCommand(args)
+	case "dump":
+		return dumpCommand(args)
+	case "import":
+		return importCommand(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
 	}
 
-	// Can't be bothered to make TLS configurable. Just
-	// use a reverse proxy for that...
-	return s.ListenAndServe()
+	fmt.Fprint(os.Stderr, usage)
+	return errors.Errorf("unknown command %q", cmd)
 }
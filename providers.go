@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// providerSeverities maps the five levels MeteoSwiss and the
+// Austrian Pollenwarndienst publish, from none to very high, to
+// the scale of the DWD.
+var providerSeverities = []string{"0", "1", "2", "2-3", "3"}
+
+// meteoSwissForecast is the pollen forecast of MeteoSwiss. It
+// contains the levels of every species for the day it was issued
+// and the two following days, per forecast region.
+type meteoSwissForecast struct {
+	Issued  string              `json:"issued"`
+	Regions []*meteoSwissRegion `json:"regions"`
+}
+
+type meteoSwissRegion struct {
+	Name    string               `json:"name"`
+	Species []*meteoSwissSpecies `json:"species"`
+}
+
+type meteoSwissSpecies struct {
+	Name   string `json:"name"`
+	Levels []int  `json:"levels"`
+}
+
+// meteoSwissSource reads the forecast of MeteoSwiss. Its regions
+// aren't split any further, so the reports have no subregion.
+type meteoSwissSource struct {
+	name string
+}
+
+func (s *meteoSwissSource) Name() string {
+	return s.name
+}
+
+func (s *meteoSwissSource) Map(payload []byte) (*sourceData, error) {
+	var data meteoSwissForecast
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, errors.Wrap(err, "unable to decode meteoswiss forecast")
+	}
+
+	result := &sourceData{LastUpdate: data.Issued}
+	forecast := newProviderForecast(data.Issued)
+
+	for _, r := range data.Regions {
+		report := forecast.location(r.Name, "")
+		for _, sp := range r.Species {
+			forecast.add(report, sp.Name, sp.Levels)
+		}
+	}
+
+	return forecast.result(result)
+}
+
+// pollenwarndienstForecast is the forecast of the Austrian
+// Pollenwarndienst. Every location lists the contamination of
+// each species for today and the following days, like the
+// polleninformation.at API does.
+type pollenwarndienstForecast struct {
+	LastUpdate string                      `json:"last_update"`
+	Locations  []*pollenwarndienstLocation `json:"locations"`
+}
+
+type pollenwarndienstLocation struct {
+	Region        string                           `json:"region"`
+	Name          string                           `json:"name"`
+	Contamination []*pollenwarndienstContamination `json:"contamination"`
+}
+
+type pollenwarndienstContamination struct {
+	// Title contains the German and the latin name of the
+	// species, e.g. "Birke (Betula)".
+	Title            string `json:"poll_title"`
+	Today            *int   `json:"contamination_1"`
+	Tomorrow         *int   `json:"contamination_2"`
+	DayAfterTomorrow *int   `json:"contamination_3"`
+}
+
+// levels returns the levels of the days we keep. Days without a
+// level end the list, so the forecast reports them as missing.
+func (c *pollenwarndienstContamination) levels() []int {
+	var levels []int
+	for _, l := range []*int{c.Today, c.Tomorrow, c.DayAfterTomorrow} {
+		if l == nil {
+			break
+		}
+		levels = append(levels, *l)
+	}
+	return levels
+}
+
+// species returns the German name of the species.
+func (c *pollenwarndienstContamination) species() string {
+	if i := strings.Index(c.Title, "("); i >= 0 {
+		return strings.TrimSpace(c.Title[:i])
+	}
+	return strings.TrimSpace(c.Title)
+}
+
+// pollenwarndienstSource reads the forecast of the Austrian
+// Pollenwarndienst.
+type pollenwarndienstSource struct {
+	name string
+}
+
+func (s *pollenwarndienstSource) Name() string {
+	return s.name
+}
+
+func (s *pollenwarndienstSource) Map(payload []byte) (*sourceData, error) {
+	var data pollenwarndienstForecast
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, errors.Wrap(err, "unable to decode pollenwarndienst forecast")
+	}
+
+	result := &sourceData{LastUpdate: data.LastUpdate}
+	forecast := newProviderForecast(data.LastUpdate)
+
+	for _, l := range data.Locations {
+		report := forecast.location(l.Region, l.Name)
+		for _, c := range l.Contamination {
+			forecast.add(report, c.species(), c.levels())
+		}
+	}
+
+	return forecast.result(result)
+}
+
+// providerForecast collects the reports of a provider which
+// publishes levels instead of the severities of the DWD.
+type providerForecast struct {
+	lastUpdate time.Time
+	reports    []*PollenReport
+	problems   []string
+}
+
+func newProviderForecast(lastUpdate string) *providerForecast {
+	f := &providerForecast{}
+
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(lastUpdate))
+	if err != nil {
+		f.problems = append(f.problems, fmt.Sprintf("invalid last update %q", lastUpdate))
+	}
+	f.lastUpdate = t
+
+	return f
+}
+
+// location adds a report for the location. Locations without a
+// name get reported as a problem.
+func (f *providerForecast) location(region, subregion string) *PollenReport {
+	report := &PollenReport{
+		Region:     strings.TrimSpace(region),
+		SubRegion:  strings.TrimSpace(subregion),
+		LastUpdate: f.lastUpdate,
+	}
+
+	if report.Region == "" {
+		f.problems = append(f.problems, fmt.Sprintf("location %d: no region name", len(f.reports)+1))
+	}
+	for _, r := range f.reports {
+		if r.Key() == report.Key() {
+			f.problems = append(f.problems, fmt.Sprintf("%s/%s: appears more than once", report.Region, report.SubRegion))
+		}
+	}
+
+	f.reports = append(f.reports, report)
+	return report
+}
+
+// add adds the levels of a species for today and the next two
+// days to the report.
+func (f *providerForecast) add(r *PollenReport, species string, levels []int) {
+	addProblem := func(format string, args ...interface{}) {
+		f.problems = append(f.problems, fmt.Sprintf("%s/%s: ", r.Region, r.SubRegion)+fmt.Sprintf(format, args...))
+	}
+
+	if r.species(slugify(species)) != nil {
+		addProblem("%s appears more than once", species)
+		return
+	}
+
+	p := sourceSpecies(r, strings.TrimSpace(species))
+	if p == nil {
+		addProblem("no species")
+		return
+	}
+
+	days := []**pollenDayReport{&p.Today, &p.Tomorrow, &p.DayAfterTomorrow}
+	if len(levels) < len(days) {
+		addProblem("%s has %d day(s) instead of %d", p.Name, len(levels), len(days))
+		return
+	}
+
+	for i, day := range days {
+		level := levels[i]
+		if level < 0 || level >= len(providerSeverities) {
+			addProblem("unknown level %d for %s", level, p.Name)
+			return
+		}
+
+		severity := providerSeverities[level]
+		*day = &pollenDayReport{severity, severityMap[severity]}
+	}
+}
+
+// result returns the reports, or a validation error if the
+// forecast can't be used.
+func (f *providerForecast) result(data *sourceData) (*sourceData, error) {
+	if len(f.reports) == 0 {
+		f.problems = append(f.problems, "forecast contains no locations")
+	}
+
+	if len(f.problems) > 0 {
+		return data, &ValidationError{f.problems}
+	}
+
+	for _, r := range f.reports {
+		sort.Slice(r.Pollen, func(i, j int) bool {
+			return r.Pollen[i].Slug < r.Pollen[j].Slug
+		})
+	}
+
+	data.Reports = f.reports
+	return data, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMeteoSwissSource(t *testing.T) {
+	t.Run("valid forecast", func(t *testing.T) {
+		payload := []byte(`{
+			"issued": "2020-04-01T09:00:00+02:00",
+			"regions": [
+				{"name": "Tessin", "species": [
+					{"name": "Gräser", "levels": [0, 1, 2]},
+					{"name": "Birke", "levels": [2, 3, 4]}
+				]}
+			]
+		}`)
+
+		data, err := (&meteoSwissSource{name: "meteoswiss"}).Map(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &sourceData{
+			LastUpdate: "2020-04-01T09:00:00+02:00",
+			Reports: []*PollenReport{
+				{
+					Region:     "Tessin",
+					LastUpdate: time.Date(2020, 4, 1, 7, 0, 0, 0, time.UTC),
+					Pollen: []*pollen{
+						{
+							Name:             "Birke",
+							Slug:             "birke",
+							LatinName:        "Betula",
+							Today:            &pollenDayReport{"2", "mittlere Belastung"},
+							Tomorrow:         &pollenDayReport{"2-3", "mittlere bis hohe Belastung"},
+							DayAfterTomorrow: &pollenDayReport{"3", "hohe Belastung"},
+						},
+						{
+							Name:             "Gräser",
+							Slug:             "graeser",
+							LatinName:        "Poaceae",
+							Today:            &pollenDayReport{"0", "keine Belastung"},
+							Tomorrow:         &pollenDayReport{"1", "geringe Belastung"},
+							DayAfterTomorrow: &pollenDayReport{"2", "mittlere Belastung"},
+						},
+					},
+				},
+			},
+		}
+		if diff := cmp.Diff(want, data, cmp.Comparer(func(a, b time.Time) bool { return a.Equal(b) })); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	testCases := map[string]struct {
+		payload  string
+		problems int
+	}{
+		"invalid issue date": {
+			`{"issued": "yesterday", "regions": [{"name": "Tessin", "species": [{"name": "Birke", "levels": [1, 1, 1]}]}]}`,
+			1,
+		},
+		"no regions": {
+			`{"issued": "2020-04-01T09:00:00Z", "regions": []}`,
+			1,
+		},
+		"missing days": {
+			`{"issued": "2020-04-01T09:00:00Z", "regions": [{"name": "Tessin", "species": [{"name": "Birke", "levels": [1]}]}]}`,
+			1,
+		},
+		"unknown level": {
+			`{"issued": "2020-04-01T09:00:00Z", "regions": [{"name": "Tessin", "species": [{"name": "Birke", "levels": [1, 5, 1]}]}]}`,
+			1,
+		},
+		"duplicate region": {
+			`{"issued": "2020-04-01T09:00:00Z", "regions": [{"name": "Tessin", "species": [{"name": "Birke", "levels": [1, 1, 1]}]}, {"name": "Tessin", "species": []}]}`,
+			1,
+		},
+	}
+
+	for description, tc := range testCases {
+		t.Run(description, func(t *testing.T) {
+			data, err := (&meteoSwissSource{name: "meteoswiss"}).Map([]byte(tc.payload))
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+			if len(verr.Problems) != tc.problems {
+				t.Errorf("expected %d problem(s), got %q", tc.problems, verr.Problems)
+			}
+			if data == nil || data.Reports != nil {
+				t.Errorf("expected no reports, got %+v", data)
+			}
+		})
+	}
+
+	if _, err := (&meteoSwissSource{}).Map([]byte("not json")); err == nil {
+		t.Error("expected an error for an undecodable payload")
+	}
+}
+
+func TestPollenwarndienstSource(t *testing.T) {
+	t.Run("valid forecast", func(t *testing.T) {
+		payload := []byte(`{
+			"last_update": "2020-04-01T09:00:00Z",
+			"locations": [
+				{"region": "Tirol", "name": "Innsbruck", "contamination": [
+					{"poll_title": "Birke (Betula)", "contamination_1": 4, "contamination_2": 3, "contamination_3": 1, "contamination_4": 0}
+				]}
+			]
+		}`)
+
+		data, err := (&pollenwarndienstSource{name: "austria"}).Map(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &sourceData{
+			LastUpdate: "2020-04-01T09:00:00Z",
+			Reports: []*PollenReport{
+				{
+					Region:     "Tirol",
+					SubRegion:  "Innsbruck",
+					LastUpdate: time.Date(2020, 4, 1, 9, 0, 0, 0, time.UTC),
+					Pollen: []*pollen{
+						{
+							Name:             "Birke",
+							Slug:             "birke",
+							LatinName:        "Betula",
+							Today:            &pollenDayReport{"3", "hohe Belastung"},
+							Tomorrow:         &pollenDayReport{"2-3", "mittlere bis hohe Belastung"},
+							DayAfterTomorrow: &pollenDayReport{"1", "geringe Belastung"},
+						},
+					},
+				},
+			},
+		}
+		if diff := cmp.Diff(want, data); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	testCases := map[string]struct {
+		payload  string
+		problems int
+	}{
+		"no region": {
+			`{"last_update": "2020-04-01T09:00:00Z", "locations": [{"name": "Innsbruck", "contamination": []}]}`,
+			1,
+		},
+		"missing day": {
+			`{"last_update": "2020-04-01T09:00:00Z", "locations": [{"region": "Tirol", "name": "Innsbruck", "contamination": [{"poll_title": "Birke (Betula)", "contamination_1": 1, "contamination_3": 1}]}]}`,
+			1,
+		},
+		"duplicate species": {
+			`{"last_update": "2020-04-01T09:00:00Z", "locations": [{"region": "Tirol", "name": "Innsbruck", "contamination": [{"poll_title": "Birke (Betula)", "contamination_1": 1, "contamination_2": 1, "contamination_3": 1}, {"poll_title": "Birke", "contamination_1": 1, "contamination_2": 1, "contamination_3": 1}]}]}`,
+			1,
+		},
+	}
+
+	for description, tc := range testCases {
+		t.Run(description, func(t *testing.T) {
+			_, err := (&pollenwarndienstSource{name: "austria"}).Map([]byte(tc.payload))
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+			if len(verr.Problems) != tc.problems {
+				t.Errorf("expected %d problem(s), got %q", tc.problems, verr.Problems)
+			}
+		})
+	}
+}
+
+func TestSourcesNamedAfterFormats(t *testing.T) {
+	defer os.Unsetenv("SOURCES")
+	defer os.Unsetenv("METEOSWISS_SOURCE")
+	defer os.Unsetenv("AUSTRIA_SOURCE")
+	defer os.Unsetenv("AUSTRIA_FORMAT")
+
+	os.Setenv("SOURCES", "meteoswiss,austria")
+	os.Setenv("METEOSWISS_SOURCE", "https://example.com/meteoswiss.json")
+	os.Setenv("AUSTRIA_SOURCE", "https://example.com/austria.json")
+	os.Setenv("AUSTRIA_FORMAT", "pollenwarndienst")
+
+	syncers, err := NewEnvSyncers(&inMemoryStorage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := syncers[0].source.(*meteoSwissSource); !ok {
+		t.Errorf("expected meteoswiss to use its own format, got %T", syncers[0].source)
+	}
+	if _, ok := syncers[1].source.(*pollenwarndienstSource); !ok || syncers[1].dataSource().Name() != "austria" {
+		t.Errorf("expected austria to use the pollenwarndienst format, got %T", syncers[1].source)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// tokenBucketScript implements a token bucket which holds up to
+// capacity tokens and refills at rate tokens per millisecond. It
+// runs atomically inside redis, so the limits hold across all
+// instances of the server.
+//
+// It returns whether the request is allowed, the number of
+// remaining tokens, the milliseconds until the next token is
+// available and the milliseconds until the bucket is full.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity / rate))
+
+local retry = 0
+if tokens < 1 then
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+return {allowed, math.floor(tokens), retry, math.ceil((capacity - tokens) / rate)}
+`)
+
+// RateLimiter limits how many requests can be made per bucket.
+type RateLimiter interface {
+	// Take removes a token from the bucket, which holds up to
+	// limit tokens and is refilled completely once per period.
+	Take(bucket string, limit int, period time.Duration) (*rateLimitResult, error)
+}
+
+type rateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// Take implements RateLimiter using a token bucket in redis.
+func (rs *RedisStorage) Take(bucket string, limit int, period time.Duration) (*rateLimitResult, error) {
+	rate := float64(limit) / float64(period/time.Millisecond)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	// Bucket names are used verbatim. Normalizing them would
+	// merge buckets like ip:1.2.3.45 and ip:12.3.4.5.
+	key := rs.makeKey("ratelimit") + ":" + bucket
+	res, err := tokenBucketScript.Run(rs.client, []string{key}, limit, rate, now).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 4 {
+		return nil, ErrUnexpectedScriptResult
+	}
+
+	ints := make([]int64, len(vals))
+	for i, v := range vals {
+		if ints[i], ok = v.(int64); !ok {
+			return nil, ErrUnexpectedScriptResult
+		}
+	}
+
+	return &rateLimitResult{
+		Allowed:    ints[0] == 1,
+		Limit:      limit,
+		Remaining:  int(ints[1]),
+		RetryAfter: time.Duration(ints[2]) * time.Millisecond,
+		Reset:      time.Duration(ints[3]) * time.Millisecond,
+	}, nil
+}
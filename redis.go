@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+)
+
+// redisConfig describes how to connect to redis. Depending on the
+// options, this is a single server, a sentinel setup or a cluster.
+type redisConfig struct {
+	options *redis.UniversalOptions
+	// cluster connects to a redis cluster. A single seed address
+	// would otherwise be treated as a single server.
+	cluster bool
+}
+
+// newEnvRedisConfig returns a redis config configured via
+// environment variables. If the required variables are not
+// set, it attempts to use sensible defaults.
+func newEnvRedisConfig() (*redisConfig, error) {
+	c := &redisConfig{
+		options: &redis.UniversalOptions{
+			Addrs:       []string{"localhost:6379"},
+			DialTimeout: 5 * time.Second,
+		},
+	}
+	o := c.options
+
+	if v, exists := os.LookupEnv("REDIS_URL"); exists {
+		u, err := redis.ParseURL(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid REDIS_URL")
+		}
+		o.Addrs = []string{u.Addr}
+		o.Password = u.Password
+		o.DB = u.DB
+		o.TLSConfig = u.TLSConfig
+	} else {
+		if v, exists := os.LookupEnv("REDIS_HOST"); exists {
+			o.Addrs = []string{v}
+		}
+		o.Password = os.Getenv("REDIS_PASSWORD")
+	}
+
+	if v, exists := os.LookupEnv("REDIS_SENTINEL_ADDRS"); exists {
+		o.Addrs = splitList(v)
+		o.MasterName = os.Getenv("REDIS_SENTINEL_MASTER")
+		if o.MasterName == "" {
+			return nil, errors.New("REDIS_SENTINEL_MASTER is required when using sentinel")
+		}
+	}
+
+	if v, exists := os.LookupEnv("REDIS_CLUSTER_ADDRS"); exists {
+		if o.MasterName != "" {
+			return nil, errors.New("redis can't be configured for sentinel and cluster at the same time")
+		}
+		o.Addrs = splitList(v)
+		c.cluster = true
+	}
+
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{"REDIS_DB", &o.DB},
+		{"REDIS_POOL_SIZE", &o.PoolSize},
+		{"REDIS_MIN_IDLE_CONNS", &o.MinIdleConns},
+	}
+	for _, i := range ints {
+		v, exists := os.LookupEnv(i.name)
+		if !exists {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid %s %q", i.name, v)
+		}
+		*i.value = n
+	}
+
+	if c.cluster && o.DB != 0 {
+		return nil, errors.New("redis cluster only supports database 0")
+	}
+
+	durations := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"REDIS_DIAL_TIMEOUT", &o.DialTimeout},
+		{"REDIS_POOL_TIMEOUT", &o.PoolTimeout},
+		{"REDIS_IDLE_TIMEOUT", &o.IdleTimeout},
+	}
+	for _, d := range durations {
+		v, exists := os.LookupEnv(d.name)
+		if !exists {
+			continue
+		}
+		t, err := time.ParseDuration(v)
+		if err != nil || t < 0 {
+			return nil, errors.Errorf("invalid %s %q", d.name, v)
+		}
+		*d.value = t
+	}
+
+	tlsConfig, err := newEnvRedisTLSConfig(o.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	o.TLSConfig = tlsConfig
+
+	return c, nil
+}
+
+// newEnvRedisTLSConfig returns the TLS config for the connection
+// to redis, or nil if TLS is disabled. The config parsed from a
+// rediss:// URL gets extended.
+func newEnvRedisTLSConfig(c *tls.Config) (*tls.Config, error) {
+	enabled, _ := strconv.ParseBool(os.Getenv("REDIS_TLS"))
+	if c == nil && !enabled {
+		return nil, nil
+	}
+	if c == nil {
+		c = &tls.Config{}
+	}
+	c.MinVersion = tls.VersionTLS12
+
+	if v := os.Getenv("REDIS_TLS_SERVER_NAME"); v != "" {
+		c.ServerName = v
+	}
+	c.InsecureSkipVerify, _ = strconv.ParseBool(os.Getenv("REDIS_TLS_SKIP_VERIFY"))
+
+	if file := os.Getenv("REDIS_TLS_CA_FILE"); file != "" {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read REDIS_TLS_CA_FILE")
+		}
+
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in %s", file)
+		}
+	}
+
+	return c, nil
+}
+
+// newClient creates the client for the configured setup.
+func (c *redisConfig) newClient() redis.UniversalClient {
+	switch {
+	case c.cluster:
+		return redis.NewClusterClient(c.options.Cluster())
+	case c.options.MasterName != "":
+		return redis.NewFailoverClient(c.options.Failover())
+	default:
+		return redis.NewClient(c.options.Simple())
+	}
+}
+
+// clusterPrefix turns the prefix into a hash tag, so all keys end
+// up in the same slot. Otherwise commands spanning multiple keys,
+// like MGET, fail in a cluster.
+func clusterPrefix(prefix string) string {
+	if strings.Contains(prefix, "{") {
+		return prefix
+	}
+	if prefix == "" {
+		prefix = "pollen"
+	}
+	return "{" + prefix + "}"
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewEnvRedisConfig(t *testing.T) {
+	testCases := map[string]struct {
+		env      map[string]string
+		expected *redis.UniversalOptions
+		cluster  bool
+		tls      bool
+		err      bool
+	}{
+		"defaults": {
+			env: map[string]string{},
+			expected: &redis.UniversalOptions{
+				Addrs:       []string{"localhost:6379"},
+				DialTimeout: 5 * time.Second,
+			},
+		},
+		"host and password": {
+			env: map[string]string{"REDIS_HOST": "redis:6380", "REDIS_PASSWORD": "::secret::", "REDIS_DB": "2"},
+			expected: &redis.UniversalOptions{
+				Addrs:       []string{"redis:6380"},
+				Password:    "::secret::",
+				DB:          2,
+				DialTimeout: 5 * time.Second,
+			},
+		},
+		"url": {
+			env: map[string]string{"REDIS_URL": "rediss://:secret@redis.example.com:6380/3", "REDIS_HOST": "ignored:6379"},
+			expected: &redis.UniversalOptions{
+				Addrs:       []string{"redis.example.com:6380"},
+				Password:    "secret",
+				DB:          3,
+				DialTimeout: 5 * time.Second,
+			},
+			tls: true,
+		},
+		"sentinel": {
+			env: map[string]string{"REDIS_SENTINEL_ADDRS": "a:26379, b:26379", "REDIS_SENTINEL_MASTER": "mymaster"},
+			expected: &redis.UniversalOptions{
+				Addrs:       []string{"a:26379", "b:26379"},
+				MasterName:  "mymaster",
+				DialTimeout: 5 * time.Second,
+			},
+		},
+		"cluster with pool settings": {
+			env: map[string]string{
+				"REDIS_CLUSTER_ADDRS":  "a:6379,b:6379",
+				"REDIS_POOL_SIZE":      "20",
+				"REDIS_MIN_IDLE_CONNS": "5",
+				"REDIS_POOL_TIMEOUT":   "2s",
+				"REDIS_IDLE_TIMEOUT":   "1m",
+				"REDIS_TLS":            "true",
+			},
+			expected: &redis.UniversalOptions{
+				Addrs:        []string{"a:6379", "b:6379"},
+				DialTimeout:  5 * time.Second,
+				PoolSize:     20,
+				MinIdleConns: 5,
+				PoolTimeout:  2 * time.Second,
+				IdleTimeout:  time.Minute,
+			},
+			cluster: true,
+			tls:     true,
+		},
+		"sentinel without master": {
+			env: map[string]string{"REDIS_SENTINEL_ADDRS": "a:26379"},
+			err: true,
+		},
+		"cluster with database": {
+			env: map[string]string{"REDIS_CLUSTER_ADDRS": "a:6379", "REDIS_DB": "1"},
+			err: true,
+		},
+		"invalid pool size": {
+			env: map[string]string{"REDIS_POOL_SIZE": "many"},
+			err: true,
+		},
+		"missing ca file": {
+			env: map[string]string{"REDIS_TLS": "true", "REDIS_TLS_CA_FILE": "/does/not/exist.pem"},
+			err: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			c, err := newEnvRedisConfig()
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (c.options.TLSConfig != nil) != tc.tls {
+				t.Errorf("expected tls to be %v, got %+v", tc.tls, c.options.TLSConfig)
+			}
+			if c.cluster != tc.cluster {
+				t.Errorf("expected cluster to be %v", tc.cluster)
+			}
+
+			c.options.TLSConfig = nil
+			if diff := cmp.Diff(tc.expected, c.options); diff != "" {
+				t.Errorf("options differ (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClusterPrefix(t *testing.T) {
+	testCases := map[string]string{
+		"":           "{pollen}",
+		"achoo":      "{achoo}",
+		"{achoo}:v2": "{achoo}:v2",
+	}
+
+	for prefix, expected := range testCases {
+		if actual := clusterPrefix(prefix); actual != expected {
+			t.Errorf("clusterPrefix(%q): expected %q, got %q", prefix, expected, actual)
+		}
+	}
+}
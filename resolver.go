@@ -0,0 +1,258 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxSuggestions is the number of names suggested if a region or
+// subregion can't be found.
+const maxSuggestions = 3
+
+var separatorRegexp = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// subregionAliases maps common names to the subregions they are
+// part of. Aliases are only used if the subregion exists.
+var subregionAliases = map[string]string{
+	"berlin":      "Brandenburg_und_Berlin",
+	"brandenburg": "Brandenburg_und_Berlin",
+	"bremen":      "Westl_Niedersachsen_Bremen",
+	"frankfurt":   "Rhein_Main",
+	"hamburg":     "Geest_Schleswig_Holstein_und_Hamburg",
+	"mecklenburg": "Mecklenburg_Vorpommern",
+	"vorpommern":  "Mecklenburg_Vorpommern",
+	"rheinmain":   "Rhein_Main",
+	"saar":        "Saarland",
+	"donau":       "Donauniederungen",
+}
+
+// notFoundResponse is returned if a region or subregion doesn't
+// exist. It suggests similar names, if there are any.
+type notFoundResponse struct {
+	Message     string   `json:"message"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+func respondNotFound(w http.ResponseWriter, suggestions []string) {
+	respond(w, http.StatusNotFound, &notFoundResponse{"No data found", suggestions})
+}
+
+// canonicalName reduces a name to the parts that matter when
+// comparing it to other names: it is lower case, umlauts are
+// transliterated and separators become a single underscore.
+func canonicalName(s string) string {
+	s = transliterations.Replace(strings.ToLower(keyRemoveRegexp.ReplaceAllLiteralString(s, "")))
+	return strings.Trim(separatorRegexp.ReplaceAllLiteralString(s, "_"), "_")
+}
+
+// nameResolver finds the stored name a user meant.
+type nameResolver struct {
+	names []string
+	// byCanonical maps canonical names and aliases to stored
+	// names.
+	byCanonical map[string]string
+}
+
+// newNameResolver returns a resolver for the stored names. Aliases
+// pointing to names which don't exist are ignored.
+func newNameResolver(names []string, aliases map[string]string) *nameResolver {
+	r := &nameResolver{
+		names:       names,
+		byCanonical: make(map[string]string),
+	}
+
+	for _, name := range names {
+		r.byCanonical[canonicalName(name)] = name
+	}
+
+	for alias, name := range aliases {
+		target, ok := r.byCanonical[canonicalName(name)]
+		if !ok {
+			continue
+		}
+		if _, exists := r.byCanonical[canonicalName(alias)]; !exists {
+			r.byCanonical[canonicalName(alias)] = target
+		}
+	}
+
+	return r
+}
+
+// resolve returns the stored name for the name or alias.
+func (r *nameResolver) resolve(name string) (string, bool) {
+	resolved, ok := r.byCanonical[canonicalName(name)]
+	return resolved, ok
+}
+
+// suggest returns up to maxSuggestions stored names which are
+// similar to name, the most similar first. Names containing it
+// come before names which are merely spelled alike. Single words
+// of a name count as well.
+func (r *nameResolver) suggest(name string) []string {
+	query := canonicalName(name)
+	if query == "" {
+		return nil
+	}
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+
+	var candidates []candidate
+	for _, stored := range r.names {
+		c := canonicalName(stored)
+
+		distance := levenshtein(query, c)
+		if strings.Contains(c, query) || strings.Contains(query, c) {
+			distance = 0
+		}
+		// Users often only type a part of long names.
+		for _, word := range strings.Split(c, "_") {
+			if d := levenshtein(query, word); d < distance {
+				distance = d
+			}
+		}
+
+		// Allow roughly one typo per three characters.
+		if distance > len([]rune(query))/3+1 {
+			continue
+		}
+		candidates = append(candidates, candidate{stored, distance})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	var suggestions []string
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		suggestions = append(suggestions, candidates[i].name)
+	}
+
+	return suggestions
+}
+
+// levenshtein returns the number of single character edits it
+// takes to turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// newEnvAliases reads additional subregion aliases from the
+// SUBREGION_ALIASES variable, e.g. "ffm=Rhein_Main,muc=Donauniederungen".
+// They extend the bundled aliases.
+func newEnvAliases() (map[string]string, error) {
+	aliases := make(map[string]string, len(subregionAliases))
+	for alias, name := range subregionAliases {
+		aliases[alias] = name
+	}
+
+	for _, pair := range splitList(os.Getenv("SUBREGION_ALIASES")) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, errors.Errorf("invalid SUBREGION_ALIASES entry %q", pair)
+		}
+		aliases[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return aliases, nil
+}
+
+// subregionResolver returns a resolver for all stored subregions.
+func (s *server) subregionResolver() (*nameResolver, error) {
+	subregions, err := s.storage.AllSubregions()
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := s.aliases
+	if aliases == nil {
+		aliases = subregionAliases
+	}
+	return newNameResolver(subregions, aliases), nil
+}
+
+// lookupSubregion loads the report of the subregion the user
+// meant. Exact names are looked up right away, everything else
+// gets resolved first. If nothing matches, it returns ErrNotFound
+// together with suggestions.
+func (s *server) lookupSubregion(name string) (*PollenReport, []string, error) {
+	report, err := s.storage.GetBySubregion(name)
+	if err != ErrNotFound {
+		return report, nil, err
+	}
+
+	res, err := s.subregionResolver()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, ok := res.resolve(name)
+	if !ok || key == normalizeString(name) {
+		return nil, res.suggest(name), ErrNotFound
+	}
+
+	report, err = s.storage.GetBySubregion(key)
+	return report, nil, err
+}
+
+// lookupRegion is the same as lookupSubregion for regions.
+func (s *server) lookupRegion(name string) ([]*PollenReport, []string, error) {
+	rs, err := s.storage.GetByRegion(name)
+	if err != ErrNotFound {
+		return rs, nil, err
+	}
+
+	regions, err := s.storage.AllRegions()
+	if err != nil {
+		return nil, nil, err
+	}
+	res := newNameResolver(regions, nil)
+
+	key, ok := res.resolve(name)
+	if !ok || key == normalizeString(name) {
+		return nil, res.suggest(name), ErrNotFound
+	}
+
+	rs, err = s.storage.GetByRegion(key)
+	return rs, nil, err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCanonicalName(t *testing.T) {
+	testCases := map[string]string{
+		"Rhein-Main":                  "rhein_main",
+		"rhein main":                  "rhein_main",
+		"  Rhein_Main ":               "rhein_main",
+		"Östl. Niedersachsen":         "oestl_niedersachsen",
+		"oestl-niedersachsen":         "oestl_niedersachsen",
+		"Westl. Niedersachsen/Bremen": "westl_niedersachsen_bremen",
+		"Großraum":                    "grossraum",
+	}
+
+	for in, want := range testCases {
+		if got := canonicalName(in); got != want {
+			t.Errorf("canonicalName(%q): expected %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestNameResolver(t *testing.T) {
+	res := newNameResolver(
+		[]string{"Rhein_Main", "Östl_Niedersachsen", "Mittelgebirge_NRW", "Mittelgebirge_Sachsen"},
+		map[string]string{"frankfurt": "Rhein_Main", "nowhere": "Atlantis"},
+	)
+
+	resolveCases := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"rhein-main", "Rhein_Main", true},
+		{"RHEIN MAIN", "Rhein_Main", true},
+		{"oestl. niedersachsen", "Östl_Niedersachsen", true},
+		{"Frankfurt", "Rhein_Main", true},
+		{"nowhere", "", false},
+		{"Rhein_Mian", "", false},
+	}
+	for _, tc := range resolveCases {
+		got, ok := res.resolve(tc.name)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("resolve(%q): expected %q %v, got %q %v", tc.name, tc.want, tc.ok, got, ok)
+		}
+	}
+
+	suggestCases := []struct {
+		name string
+		want []string
+	}{
+		{"Rhein_Mian", []string{"Rhein_Main"}},
+		{"mittelgebirge", []string{"Mittelgebirge_NRW", "Mittelgebirge_Sachsen"}},
+		{"niedersachsn", []string{"Östl_Niedersachsen"}},
+		{"Bayern", nil},
+	}
+	for _, tc := range suggestCases {
+		if diff := cmp.Diff(tc.want, res.suggest(tc.name)); diff != "" {
+			t.Errorf("suggest(%q): %s", tc.name, diff)
+		}
+	}
+}
+
+func TestNewEnvAliases(t *testing.T) {
+	defer os.Unsetenv("SUBREGION_ALIASES")
+
+	os.Setenv("SUBREGION_ALIASES", "ffm=Rhein_Main, muc = Donauniederungen")
+	aliases, err := newEnvAliases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliases["ffm"] != "Rhein_Main" || aliases["muc"] != "Donauniederungen" || aliases["berlin"] == "" {
+		t.Errorf("expected configured and bundled aliases, got %v", aliases)
+	}
+
+	os.Setenv("SUBREGION_ALIASES", "ffm")
+	if _, err := newEnvAliases(); err == nil {
+		t.Error("expected an error for an alias without subregion")
+	}
+}
+
+func TestLookupEndpoints(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	s := createServer()
+	s.storage = newStorage(mr)
+	s.aliases = map[string]string{"aa": "subregion-aa"}
+
+	testCases := []struct {
+		url         string
+		status      int
+		suggestions []string
+	}{
+		{"/pollen/subregion/subregion_aa", http.StatusOK, nil},
+		{"/pollen/subregion/Subregion-AA", http.StatusOK, nil},
+		{"/pollen/subregion/SUBREGION%20AA", http.StatusOK, nil},
+		{"/pollen/subregion/aa", http.StatusOK, nil},
+		{"/pollen/subregion/subregion-ax", http.StatusNotFound, []string{"subregion_aa", "subregion_ab", "subregion_ba"}},
+		{"/pollen/subregion/region-cc", http.StatusNotFound, []string{"region_c"}},
+		{"/pollen/subregion/nothing-like-it", http.StatusNotFound, nil},
+		{"/pollen/region/REGION-A", http.StatusOK, nil},
+		{"/pollen/region/region-x/summary", http.StatusNotFound, []string{"region_a", "region_b", "region_c"}},
+		{"/risk?subregion=Region%20C&profile=roggen:3", http.StatusOK, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+
+			if w.Code != tc.status {
+				t.Fatalf("expected %d, got %d: %s", tc.status, w.Code, w.Body)
+			}
+			if tc.status != http.StatusNotFound {
+				return
+			}
+
+			var res notFoundResponse
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.suggestions, res.Suggestions); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxSensitivity is the highest weight a user can assign
+	// to a species in their profile.
+	maxSensitivity = 3
+	// maxRiskContributors is the number of species listed as
+	// the top contributors of a risk score.
+	maxRiskContributors = 3
+
+	defaultRiskModel = "combined"
+)
+
+// riskModels contains all available models, indexed by the
+// name used to select them via the model query parameter.
+var riskModels = map[string]RiskModel{
+	"combined": combinedRiskModel{},
+	"max":      maxRiskModel{},
+}
+
+// RiskModel turns the forecast of a single day and a user's
+// sensitivities into a risk score between 0 and 100.
+//
+// Models get passed the exposure of each species, which is the
+// severity level scaled to [0, 1] multiplied by the user's
+// sensitivity scaled to [0, 1].
+type RiskModel interface {
+	Score(exposures []*riskContributor) int
+}
+
+// riskProfile maps species slugs to the user's sensitivity.
+type riskProfile map[string]float64
+
+type riskResponse struct {
+	Region     string                `json:"region"`
+	SubRegion  string                `json:"sub_region"`
+	Model      string                `json:"model"`
+	LastUpdate time.Time             `json:"last_update"`
+	Days       map[string]*riskScore `json:"days"`
+}
+
+type riskScore struct {
+	Date  string             `json:"date"`
+	Score int                `json:"score"`
+	Level string             `json:"level"`
+	Top   []*riskContributor `json:"top"`
+}
+
+type riskContributor struct {
+	Species      string  `json:"species"`
+	Name         string  `json:"name"`
+	Severity     string  `json:"severity"`
+	Weight       float64 `json:"weight"`
+	Contribution int     `json:"contribution"`
+
+	exposure float64
+}
+
+// combinedRiskModel treats every exposure as an independent
+// chance of having symptoms and returns the chance of having
+// symptoms from at least one species:
+//
+//	score = 100 * (1 - (1 - e1) * (1 - e2) * ... * (1 - en))
+//
+// A single species at the highest severity with the highest
+// sensitivity results in a score of 100. Several moderate
+// exposures add up to a higher score than any one of them.
+type combinedRiskModel struct{}
+
+func (combinedRiskModel) Score(exposures []*riskContributor) int {
+	p := 1.0
+	for _, e := range exposures {
+		p *= 1 - e.exposure
+	}
+	return int(math.Round(100 * (1 - p)))
+}
+
+// maxRiskModel only considers the worst exposure:
+//
+//	score = 100 * max(e1, e2, ..., en)
+type maxRiskModel struct{}
+
+func (maxRiskModel) Score(exposures []*riskContributor) int {
+	max := 0.0
+	for _, e := range exposures {
+		max = math.Max(max, e.exposure)
+	}
+	return int(math.Round(100 * max))
+}
+
+// parseRiskProfile parses profiles like "birke:3,graeser:1". If
+// the profile is invalid, it returns a message explaining why.
+func parseRiskProfile(v string) (riskProfile, string) {
+	profile := make(riskProfile)
+
+	for _, entry := range strings.Split(v, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		sp, ok := lookupSpeciesBySlug(parts[0])
+		if !ok {
+			return nil, "Unknown species " + strings.TrimSpace(parts[0])
+		}
+
+		weight := float64(maxSensitivity)
+		if len(parts) == 2 {
+			w, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			// NaN passes any comparison, so it has to be ruled
+			// out explicitly.
+			if err != nil || math.IsNaN(w) || math.IsInf(w, 0) || w < 0 || w > maxSensitivity {
+				return nil, "Sensitivity has to be a number between 0 and 3"
+			}
+			weight = w
+		}
+
+		profile[sp.Slug] = weight
+	}
+
+	if len(profile) == 0 {
+		return nil, "profile is required, e.g. profile=birke:3,graeser:1"
+	}
+
+	return profile, ""
+}
+
+// scoreReport computes the risk score for every day of the report.
+func scoreReport(r *PollenReport, profile riskProfile, model RiskModel) map[string]*riskScore {
+	days := make(map[string]*riskScore)
+
+	for _, day := range []string{dayToday, dayTomorrow, dayDayAfterTomorrow} {
+		var exposures []*riskContributor
+		for _, p := range r.Pollen {
+			weight, ok := profile[p.Slug]
+			if !ok {
+				continue
+			}
+
+			d := p.day(day)
+			if d == nil {
+				continue
+			}
+
+			level, ok := severityLevel(d.Severity)
+			if !ok {
+				continue
+			}
+
+			exposure := level / 3 * weight / maxSensitivity
+			exposures = append(exposures, &riskContributor{
+				Species:      p.Slug,
+				Name:         p.Name,
+				Severity:     d.Severity,
+				Weight:       weight,
+				Contribution: int(math.Round(100 * exposure)),
+				exposure:     exposure,
+			})
+		}
+
+		score := model.Score(exposures)
+		days[day] = &riskScore{
+			Date:  r.Date().AddDate(0, 0, dayOffset(day)).Format("2006-01-02"),
+			Score: score,
+			Level: riskLevel(score),
+			Top:   topContributors(exposures),
+		}
+	}
+
+	return days
+}
+
+func topContributors(exposures []*riskContributor) []*riskContributor {
+	sort.SliceStable(exposures, func(i, j int) bool {
+		return exposures[i].exposure > exposures[j].exposure
+	})
+
+	top := []*riskContributor{}
+	for _, e := range exposures {
+		if e.exposure == 0 || len(top) == maxRiskContributors {
+			break
+		}
+		top = append(top, e)
+	}
+
+	return top
+}
+
+func riskLevel(score int) string {
+	switch {
+	case score >= 80:
+		return "very_high"
+	case score >= 60:
+		return "high"
+	case score >= 35:
+		return "moderate"
+	case score > 0:
+		return "low"
+	}
+	return "none"
+}
+
+func (s *server) handleGetRisk() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		profile, msg := parseRiskProfile(q.Get("profile"))
+		if msg != "" {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{msg})
+			return
+		}
+
+		modelName := q.Get("model")
+		if modelName == "" {
+			modelName = defaultRiskModel
+		}
+		model, ok := riskModels[modelName]
+		if !ok {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{"Unknown model " + modelName})
+			return
+		}
+
+		subregion := q.Get("subregion")
+		if subregion == "" {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{"subregion is required"})
+			return
+		}
+
+		report, suggestions, err := s.lookupSubregion(subregion)
+		if err != nil {
+			if err == ErrNotFound {
+				respondNotFound(w, suggestions)
+				return
+			}
+
+			log.Printf("[routes] unable to load report: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		respond(w, http.StatusOK, &riskResponse{
+			Region:     report.Region,
+			SubRegion:  report.SubRegion,
+			Model:      modelName,
+			LastUpdate: report.LastUpdate,
+			Days:       scoreReport(report, profile, model),
+		})
+	}
+}
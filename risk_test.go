@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRiskProfile(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  riskProfile
+		valid bool
+	}{
+		{"birke:3,graeser:1", riskProfile{"birke": 3, "graeser": 1}, true},
+		{"Birke:1.5, hasel", riskProfile{"birke": 1.5, "hasel": 3}, true},
+		{"", nil, false},
+		{"kaktus:2", nil, false},
+		{"birke:4", nil, false},
+		{"birke:viel", nil, false},
+		{"birke:NaN", nil, false},
+		{"birke:nan", nil, false},
+		{"birke:Inf", nil, false},
+		{"birke:-Inf", nil, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got, msg := parseRiskProfile(tc.in)
+			if (msg == "") != tc.valid {
+				t.Fatalf("expected valid=%v, got message %q", tc.valid, msg)
+			}
+			if !cmp.Equal(got, tc.want) {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRiskModels(t *testing.T) {
+	exposures := func(es ...float64) []*riskContributor {
+		var cs []*riskContributor
+		for _, e := range es {
+			cs = append(cs, &riskContributor{exposure: e})
+		}
+		return cs
+	}
+
+	tests := []struct {
+		description string
+		model       RiskModel
+		exposures   []*riskContributor
+		want        int
+	}{
+		{"combined without exposure", combinedRiskModel{}, exposures(), 0},
+		{"combined single exposure", combinedRiskModel{}, exposures(0.5), 50},
+		{"combined adds up", combinedRiskModel{}, exposures(0.5, 0.5), 75},
+		{"combined maximum", combinedRiskModel{}, exposures(1, 0.2), 100},
+		{"max only uses worst", maxRiskModel{}, exposures(0.5, 0.25), 50},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if got := tc.model.Score(tc.exposures); got != tc.want {
+				t.Errorf("want %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestScoreReport(t *testing.T) {
+	r := reportWithSeverities("subregion-aa", map[string]string{"birke": "3", "graeser": "1-2", "hasel": "2"})
+
+	got := scoreReport(r, riskProfile{"birke": 3, "graeser": 1.5}, combinedRiskModel{})
+
+	today := got[dayToday]
+	// birke: 3/3 * 3/3 = 1, so the score has to be 100.
+	if today.Score != 100 || today.Level != "very_high" {
+		t.Errorf("unexpected score for today %+v", today)
+	}
+
+	if len(today.Top) != 2 || today.Top[0].Species != "birke" || today.Top[1].Species != "graeser" {
+		t.Errorf("unexpected top contributors %+v", today.Top)
+	}
+
+	if today.Top[1].Contribution != 25 {
+		t.Errorf("expected graeser to contribute 25, got %d", today.Top[1].Contribution)
+	}
+
+	if tomorrow := got[dayTomorrow]; tomorrow.Score != 0 || len(tomorrow.Top) != 0 {
+		t.Errorf("expected no risk tomorrow, got %+v", tomorrow)
+	}
+}
+
+func TestRiskEndpoint(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	s := createServer()
+	s.storage = newStorage(mr)
+
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"/risk?subregion=subregion_aa&profile=roggen:2", http.StatusOK},
+		{"/risk?subregion=subregion_aa&profile=roggen:2&model=max", http.StatusOK},
+		{"/risk?subregion=subregion_aa&profile=roggen:2&model=magic", http.StatusBadRequest},
+		{"/risk?subregion=subregion_aa&profile=roggen:NaN", http.StatusBadRequest},
+		{"/risk?subregion=subregion_aa", http.StatusBadRequest},
+		{"/risk?profile=roggen:2", http.StatusBadRequest},
+		{"/risk?subregion=nope&profile=roggen:2", http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+
+			if w.Code != tc.want {
+				t.Errorf("wanted status %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+}
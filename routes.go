@@ -13,12 +13,29 @@ type invalidRequestResponse struct {
 }
 
 func (s *server) routes() {
+	s.router.Use(s.limitWriteTime, s.compressResponses, s.warnDegraded, s.limitAccess)
+
 	s.router.HandleFunc("/ping", s.handlePing()).Methods("GET")
-	s.router.HandleFunc("/regions", s.handleGetRegions()).Methods("GET")
-	s.router.HandleFunc("/subregions", s.handleGetSubregions()).Methods("GET")
-	s.router.HandleFunc("/pollen", s.HandleGetAllReports()).Methods("GET")
-	s.router.HandleFunc("/pollen/subregion/{subregion}", s.handleGetSubRegion()).Methods("GET")
-	s.router.HandleFunc("/pollen/region/{region}", s.handleGetRegion()).Methods("GET")
+	s.router.HandleFunc("/regions", s.cached(s.handleGetRegions())).Methods("GET")
+	s.router.HandleFunc("/subregions", s.cached(s.handleGetSubregions())).Methods("GET")
+	s.router.HandleFunc("/species", s.cached(s.handleGetSpecies())).Methods("GET")
+	s.router.HandleFunc("/risk", s.cached(s.handleGetRisk())).Methods("GET")
+	s.router.HandleFunc("/pollen", s.cached(s.HandleGetAllReports())).Methods("GET")
+	s.streamFunc("/pollen/stream", s.handleStream()).Methods("GET")
+	s.router.HandleFunc("/pollen/changes", s.handleGetChanges()).Methods("GET")
+	s.router.HandleFunc("/pollen/summary", s.cached(s.handleGetSummary())).Methods("GET")
+	s.router.HandleFunc("/pollen/batch", s.cached(s.handleGetBatch())).Methods("GET")
+	s.router.HandleFunc("/pollen/batch", s.handleGetBatch()).Methods("POST")
+	s.router.HandleFunc("/pollen/subregion/{subregion}", s.cached(s.handleGetSubRegion())).Methods("GET")
+	s.router.HandleFunc("/pollen/subregion/{subregion}/trend", s.cached(s.handleGetTrend())).Methods("GET")
+	s.router.HandleFunc("/pollen/subregion/{subregion}/calendar.ics", s.handleGetCalendarFeed()).Methods("GET")
+	s.router.HandleFunc("/pollen/region/{region}", s.cached(s.handleGetRegion())).Methods("GET")
+	s.router.HandleFunc("/pollen/region/{region}/summary", s.cached(s.handleGetRegionSummary())).Methods("GET")
+	s.router.HandleFunc("/calendar/{subregion}", s.cached(s.handleGetSeasonCalendar())).Methods("GET")
+	s.router.HandleFunc("/search", s.cached(s.handleSearch())).Methods("GET")
+
+	s.subscriptionRoutes()
+	s.adminRoutes()
 }
 
 func (s *server) handlePing() http.HandlerFunc {
@@ -33,6 +50,12 @@ func (s *server) handlePing() http.HandlerFunc {
 
 func (s *server) HandleGetAllReports() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		view, msg := parseReportView(r)
+		if msg != "" {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{msg})
+			return
+		}
+
 		rs, err := s.storage.AllReports()
 		if err != nil {
 			log.Println(err)
@@ -40,19 +63,23 @@ func (s *server) HandleGetAllReports() http.HandlerFunc {
 			return
 		}
 
-		respond(w, http.StatusOK, rs)
+		rs = view.filter(filterSource(rs, r.URL.Query().Get("source")))
+		respondExport(w, r, http.StatusOK, view.export(rs), view.renderAll(rs))
 	}
 }
 
 func (s *server) handleGetSubRegion() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		v := mux.Vars(r)
-		subRegion := v["subregion"]
+		view, msg := parseReportView(r)
+		if msg != "" {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{msg})
+			return
+		}
 
-		data, err := s.storage.GetBySubregion(subRegion)
+		data, suggestions, err := s.lookupSubregion(subregionParam(r))
 		if err != nil {
 			if err == ErrNotFound {
-				respond(w, http.StatusNotFound, &invalidRequestResponse{"No data found"})
+				respondNotFound(w, suggestions)
 				return
 			}
 
@@ -60,7 +87,8 @@ func (s *server) handleGetSubRegion() http.HandlerFunc {
 			return
 		}
 
-		respond(w, http.StatusOK, data)
+		data = view.filter([]*PollenReport{data})[0]
+		respondExport(w, r, http.StatusOK, view.export([]*PollenReport{data}), view.render(data))
 	}
 }
 
@@ -88,14 +116,26 @@ func (s *server) handleGetSubregions() http.HandlerFunc {
 	}
 }
 
+func (s *server) handleGetSpecies() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, speciesRegistry)
+	}
+}
+
 func (s *server) handleGetRegion() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		view, msg := parseReportView(r)
+		if msg != "" {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{msg})
+			return
+		}
+
 		reg := mux.Vars(r)["region"]
 
-		rs, err := s.storage.GetByRegion(reg)
+		rs, suggestions, err := s.lookupRegion(reg)
 		if err != nil {
 			if err == ErrNotFound {
-				respond(w, http.StatusNotFound, &invalidRequestResponse{"No data found"})
+				respondNotFound(w, suggestions)
 				return
 			}
 
@@ -103,7 +143,8 @@ func (s *server) handleGetRegion() http.HandlerFunc {
 			return
 		}
 
-		respond(w, http.StatusOK, rs)
+		rs = view.filter(filterSource(rs, r.URL.Query().Get("source")))
+		respondExport(w, r, http.StatusOK, view.export(rs), view.renderAll(rs))
 	}
 }
 
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+
+	// maxPrefixLength is the length of the longest word prefix
+	// which gets indexed. Longer queries are checked against the
+	// words directly.
+	maxPrefixLength = 12
+
+	// minTrigramSimilarity is how similar a name has to be to the
+	// query to count as a match if it doesn't start with it.
+	minTrigramSimilarity = 0.3
+
+	matchSubregion  = "subregion"
+	matchRegion     = "region"
+	matchCity       = "city"
+	matchPostalCode = "postal_code"
+)
+
+// matchTypes ranks matches with the same score.
+var matchTypes = map[string]int{
+	matchSubregion:  0,
+	matchRegion:     1,
+	matchCity:       2,
+	matchPostalCode: 3,
+}
+
+// searchResult is a place matching a search. Key identifies the
+// subregion the place is part of and can be used with all other
+// endpoints taking a subregion. For regions, it identifies the
+// region instead.
+type searchResult struct {
+	Type      string  `json:"type"`
+	Name      string  `json:"name"`
+	Region    string  `json:"region"`
+	SubRegion string  `json:"sub_region"`
+	Key       string  `json:"key"`
+	Score     float64 `json:"score"`
+}
+
+type searchEntry struct {
+	result searchResult
+	// term is the canonical name which gets indexed.
+	term     string
+	words    []string
+	trigrams int
+}
+
+// searchIndex finds regions, subregions, cities and postal codes
+// by prefix or, for misspelled queries, by trigram similarity. It
+// is rebuilt from the storage after every sync.
+type searchIndex struct {
+	storage Storage
+
+	mu       sync.RWMutex
+	entries  []*searchEntry
+	prefixes map[string][]int
+	trigrams map[string][]int
+}
+
+func newSearchIndex(storage Storage) *searchIndex {
+	return &searchIndex{
+		storage:  storage,
+		prefixes: make(map[string][]int),
+		trigrams: make(map[string][]int),
+	}
+}
+
+// listen rebuilds the index whenever updated reports get
+// published, including the ones synced by this instance.
+func (i *searchIndex) listen(bus UpdateBus) {
+	ch, _ := bus.Subscribe()
+	for range ch {
+		i.refresh()
+	}
+}
+
+// refresh rebuilds the index from the reports in the storage. The
+// old index keeps being used if the reports can't be loaded.
+func (i *searchIndex) refresh() {
+	rs, err := i.storage.AllReports()
+	if err != nil {
+		log.Printf("[search] unable to load reports: %q", err.Error())
+		return
+	}
+
+	i.build(searchEntries(rs, bundledCities))
+}
+
+func (i *searchIndex) build(entries []*searchEntry) {
+	prefixes := make(map[string][]int)
+	trigrams := make(map[string][]int)
+
+	for id, e := range entries {
+		seen := make(map[string]bool)
+		for _, w := range e.words {
+			runes := []rune(w)
+			for n := 1; n <= len(runes) && n <= maxPrefixLength; n++ {
+				p := string(runes[:n])
+				if !seen[p] {
+					seen[p] = true
+					prefixes[p] = append(prefixes[p], id)
+				}
+			}
+		}
+
+		tris := trigramsOf(e.term)
+		e.trigrams = len(tris)
+		for t := range tris {
+			trigrams[t] = append(trigrams[t], id)
+		}
+	}
+
+	i.mu.Lock()
+	i.entries = entries
+	i.prefixes = prefixes
+	i.trigrams = trigrams
+	i.mu.Unlock()
+}
+
+// searchEntries returns an entry for every subregion and region
+// in the reports and every city whose subregion exists. Regions
+// with several subregions only get a single entry.
+func searchEntries(rs []*PollenReport, cities []*city) []*searchEntry {
+	var entries []*searchEntry
+	addResult := func(result searchResult, term string) {
+		term = canonicalName(term)
+		entries = append(entries, &searchEntry{
+			result: result,
+			term:   term,
+			words:  strings.Split(term, "_"),
+		})
+	}
+	add := func(typ, name, term string, r *PollenReport) {
+		addResult(searchResult{
+			Type:      typ,
+			Name:      name,
+			Region:    r.Region,
+			SubRegion: r.SubRegion,
+			Key:       r.Key(),
+		}, term)
+	}
+
+	keys := make([]string, len(rs))
+	byKey := make(map[string]*PollenReport, len(rs))
+	regions := make(map[string]bool)
+	for i, r := range rs {
+		keys[i] = r.Key()
+		byKey[r.Key()] = r
+
+		if r.SubRegion != "" {
+			add(matchSubregion, r.SubRegion, r.SubRegion, r)
+		}
+
+		region := normalizeString(r.Region)
+		if regions[region] {
+			continue
+		}
+		regions[region] = true
+		addResult(searchResult{Type: matchRegion, Name: r.Region, Region: r.Region, Key: region}, r.Region)
+	}
+
+	res := newNameResolver(keys, nil)
+	for _, c := range cities {
+		key, ok := res.resolve(c.Subregion)
+		if !ok {
+			continue
+		}
+		r := byKey[key]
+
+		add(matchCity, c.Name, c.Name, r)
+		for _, code := range postalCodePrefixes(c.PostalCodes) {
+			add(matchPostalCode, fmt.Sprintf("%sxx %s", code, c.Name), code, r)
+		}
+	}
+
+	return entries
+}
+
+// postalCodePrefixes expands a range like "603-605" into all
+// prefixes it contains.
+func postalCodePrefixes(codes string) []string {
+	parts := strings.SplitN(codes, "-", 2)
+	from, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil
+	}
+	to := from
+	if len(parts) == 2 {
+		if to, err = strconv.Atoi(parts[1]); err != nil {
+			return nil
+		}
+	}
+
+	var prefixes []string
+	for n := from; n <= to; n++ {
+		prefixes = append(prefixes, fmt.Sprintf("%03d", n))
+	}
+	return prefixes
+}
+
+// search returns up to limit entries matching the query, the best
+// matches first. Exact matches score 100, names starting with the
+// query 80 and names whose words start with the words of the query
+// 60. Everything else scores up to 50 depending on how many
+// trigrams it shares with the query.
+func (i *searchIndex) search(q string, limit int) []*searchResult {
+	query := canonicalName(q)
+	if query == "" {
+		return []*searchResult{}
+	}
+	// Postal codes are only indexed by their first three digits.
+	if _, err := strconv.Atoi(query); err == nil && len(query) > 3 {
+		query = query[:3]
+	}
+	words := strings.Split(query, "_")
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	scores := make(map[int]float64)
+
+	first := []rune(words[0])
+	if len(first) > maxPrefixLength {
+		first = first[:maxPrefixLength]
+	}
+	for _, id := range i.prefixes[string(first)] {
+		e := i.entries[id]
+		if !matchesWords(e.words, words) {
+			continue
+		}
+
+		switch {
+		case e.term == query:
+			scores[id] = 100
+		case strings.HasPrefix(e.term, query):
+			scores[id] = 80
+		default:
+			scores[id] = 60
+		}
+	}
+
+	tris := trigramsOf(query)
+	shared := make(map[int]int)
+	for t := range tris {
+		for _, id := range i.trigrams[t] {
+			shared[id]++
+		}
+	}
+	for id, n := range shared {
+		similarity := float64(n) / float64(len(tris)+i.entries[id].trigrams-n)
+		if similarity < minTrigramSimilarity {
+			continue
+		}
+		if score := math.Round(50*similarity*100) / 100; score > scores[id] {
+			scores[id] = score
+		}
+	}
+
+	results := make([]*searchResult, 0, len(scores))
+	for id, score := range scores {
+		r := i.entries[id].result
+		r.Score = score
+		results = append(results, &r)
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		ra, rb := results[a], results[b]
+		if ra.Score != rb.Score {
+			return ra.Score > rb.Score
+		}
+		if ra.Type != rb.Type {
+			return matchTypes[ra.Type] < matchTypes[rb.Type]
+		}
+		if ra.Name != rb.Name {
+			return ra.Name < rb.Name
+		}
+		return ra.Key < rb.Key
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// matchesWords checks if every word of the query is the prefix of
+// one of the words.
+func matchesWords(words, query []string) bool {
+	for _, q := range query {
+		found := false
+		for _, w := range words {
+			if strings.HasPrefix(w, q) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// trigramsOf returns the set of trigrams of the term. The term
+// gets padded, so short terms have trigrams as well.
+func trigramsOf(term string) map[string]bool {
+	runes := []rune("_" + term + "_")
+
+	tris := make(map[string]bool)
+	for n := 0; n+3 <= len(runes); n++ {
+		tris[string(runes[n:n+3])] = true
+	}
+	return tris
+}
+
+func (s *server) handleSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.search == nil {
+			respond(w, http.StatusServiceUnavailable, &invalidRequestResponse{"Search is not available"})
+			return
+		}
+
+		q := r.URL.Query()
+
+		query := strings.TrimSpace(q.Get("q"))
+		if query == "" {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{"q is required"})
+			return
+		}
+
+		limit := defaultSearchLimit
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxSearchLimit {
+				respond(w, http.StatusBadRequest, &invalidRequestResponse{fmt.Sprintf("limit has to be between 1 and %d", maxSearchLimit)})
+				return
+			}
+			limit = n
+		}
+
+		respond(w, http.StatusOK, s.search.search(query, limit))
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestSearchIndex() *searchIndex {
+	rs := []*PollenReport{
+		{Region: "Hessen", SubRegion: "Rhein-Main"},
+		{Region: "Hessen", SubRegion: "Nordhessen und hess. Mittelgebirge"},
+		{Region: "Niedersachsen und Bremen", SubRegion: "Östl. Niedersachsen"},
+		{Region: "Mecklenburg-Vorpommern"},
+	}
+	cities := []*city{
+		{"Frankfurt am Main", "603-605", "Rhein_Main"},
+		{"Hannover", "301-301", "Östl_Niedersachsen"},
+		{"Atlantis", "999-999", "Atlantis"},
+	}
+
+	i := newSearchIndex(nil)
+	i.build(searchEntries(rs, cities))
+	return i
+}
+
+func TestSearchIndex(t *testing.T) {
+	i := newTestSearchIndex()
+
+	type match struct {
+		Type string
+		Name string
+		Key  string
+	}
+
+	testCases := []struct {
+		query string
+		want  []match
+	}{
+		{"rhein main", []match{
+			{matchSubregion, "Rhein-Main", "Rhein_Main"},
+		}},
+		{"Frank", []match{
+			{matchCity, "Frankfurt am Main", "Rhein_Main"},
+		}},
+		{"hess", []match{
+			{matchRegion, "Hessen", "Hessen"},
+			{matchSubregion, "Nordhessen und hess. Mittelgebirge", "Nordhessen_und_hess_Mittelgebirge"},
+		}},
+		{"oestl niedersachsen", []match{
+			{matchSubregion, "Östl. Niedersachsen", "Östl_Niedersachsen"},
+			// Similar enough to be suggested as well.
+			{matchRegion, "Niedersachsen und Bremen", "Niedersachsen_und_Bremen"},
+		}},
+		{"60325", []match{
+			{matchPostalCode, "603xx Frankfurt am Main", "Rhein_Main"},
+		}},
+		{"30", []match{
+			{matchPostalCode, "301xx Hannover", "Östl_Niedersachsen"},
+		}},
+		{"mecklenburg", []match{
+			{matchRegion, "Mecklenburg-Vorpommern", "Mecklenburg_Vorpommern"},
+		}},
+		{"Hanover", []match{
+			{matchCity, "Hannover", "Östl_Niedersachsen"},
+		}},
+		{"atlantis", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			var got []match
+			for _, r := range i.search(tc.query, 3) {
+				got = append(got, match{r.Type, r.Name, r.Key})
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+
+	t.Run("ranking", func(t *testing.T) {
+		rs := i.search("rhein-main", 10)
+		if len(rs) == 0 || rs[0].Score != 100 {
+			t.Fatalf("expected an exact match first, got %+v", rs)
+		}
+		for n := 1; n < len(rs); n++ {
+			if rs[n].Score > rs[n-1].Score {
+				t.Errorf("expected results to be sorted by score, got %+v", rs)
+			}
+		}
+	})
+}
+
+func TestSearchEndpoint(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+
+	s := createServer()
+	s.storage = storage
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/search?q=region", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without index, got %d", w.Code)
+	}
+
+	s.search = newSearchIndex(storage)
+	s.search.refresh()
+
+	testCases := []struct {
+		url    string
+		status int
+		// first is the key of the best match.
+		first   string
+		results int
+	}{
+		{"/search?q=subregion", http.StatusOK, "subregion_aa", 6},
+		{"/search?q=subregion-aa", http.StatusOK, "subregion_aa", 6},
+		{"/search?q=region-c", http.StatusOK, "region_c", 6},
+		{"/search?q=region&limit=2", http.StatusOK, "region_a", 2},
+		{"/search?q=nothing", http.StatusOK, "", 0},
+		{"/search", http.StatusBadRequest, "", 0},
+		{"/search?q=region&limit=100", http.StatusBadRequest, "", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+
+			if w.Code != tc.status {
+				t.Fatalf("expected %d, got %d: %s", tc.status, w.Code, w.Body)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var rs []*searchResult
+			if err := json.NewDecoder(w.Body).Decode(&rs); err != nil {
+				t.Fatal(err)
+			}
+			if rs == nil || len(rs) != tc.results {
+				t.Fatalf("expected %d results, got %d", tc.results, len(rs))
+			}
+			if len(rs) > 0 && rs[0].Key != tc.first {
+				t.Errorf("expected %s first, got %+v", tc.first, rs[0])
+			}
+		})
+	}
+
+	t.Run("refreshed on sync", func(t *testing.T) {
+		bus := newLocalBus()
+		go s.search.listen(bus)
+
+		r := createPollenReport("region-d", "subregion-da")
+		if err := storage.Save(r); err != nil {
+			t.Fatal(err)
+		}
+
+		// The listener might not be subscribed yet.
+		var rs []*searchResult
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			(&updatePublisher{bus}).ReportsSynced([]*ReportUpdate{{nil, r}})
+			if rs = s.search.search("subregion-da", 1); len(rs) == 1 && rs[0].Key == "subregion_da" {
+				break
+			}
+		}
+
+		if len(rs) != 1 || rs[0].Key != "subregion_da" {
+			t.Errorf("expected new subregion to be found, got %+v", rs)
+		}
+	})
+}
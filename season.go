@@ -0,0 +1,204 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+const (
+	// seasonThreshold is the mean weekly severity at which a
+	// species counts as flying.
+	seasonThreshold = 1
+
+	// minSeasonHistory is the number of days the history has to
+	// span before seasons get computed from it. Anything less
+	// doesn't cover a whole year.
+	minSeasonHistory = 365
+
+	basisHistory = "history"
+	basisStatic  = "static"
+)
+
+// seasonWeeks are the ISO weeks of a species' pollen season.
+type seasonWeeks struct {
+	Start int `json:"start_week"`
+	Peak  int `json:"peak_week"`
+	End   int `json:"end_week"`
+}
+
+// staticSeasons is the typical season of every species in Germany,
+// based on the pollen calendar published by the DWD. It is used
+// until there is enough history to compute the seasons of a
+// subregion.
+var staticSeasons = map[string]*seasonWeeks{
+	"hasel":    {Start: 2, Peak: 8, End: 14},
+	"erle":     {Start: 4, Peak: 10, End: 16},
+	"esche":    {Start: 12, Peak: 15, End: 19},
+	"birke":    {Start: 13, Peak: 16, End: 20},
+	"graeser":  {Start: 19, Peak: 24, End: 32},
+	"roggen":   {Start: 20, Peak: 23, End: 28},
+	"beifuss":  {Start: 27, Peak: 32, End: 37},
+	"ambrosia": {Start: 31, Peak: 36, End: 41},
+}
+
+// seasonCalendar lists the pollen seasons of a subregion.
+type seasonCalendar struct {
+	Region    string    `json:"region"`
+	SubRegion string    `json:"sub_region"`
+	Pollen    []*season `json:"pollen"`
+}
+
+type season struct {
+	Name      string `json:"name"`
+	Slug      string `json:"slug"`
+	LatinName string `json:"latin_name"`
+	seasonWeeks
+	// Basis tells whether the season was computed from our
+	// history or taken from the static calendar.
+	Basis string `json:"basis"`
+}
+
+// buildSeasonCalendar returns the seasons of all species in the
+// current report. Seasons are computed from the history if it
+// covers at least a year, otherwise the static calendar is used.
+// Species without either are left out.
+func buildSeasonCalendar(current *PollenReport, history []*PollenReport) *seasonCalendar {
+	c := &seasonCalendar{
+		Region:    current.Region,
+		SubRegion: current.SubRegion,
+		Pollen:    []*season{},
+	}
+
+	var computed map[string]*seasonWeeks
+	if spansYear(history) {
+		computed = computeSeasons(history)
+	}
+
+	for _, p := range current.Pollen {
+		s := &season{Name: p.Name, Slug: p.Slug, LatinName: p.LatinName}
+
+		if w, ok := computed[p.Slug]; ok {
+			s.seasonWeeks = *w
+			s.Basis = basisHistory
+		} else if w, ok := staticSeasons[p.Slug]; ok {
+			s.seasonWeeks = *w
+			s.Basis = basisStatic
+		} else {
+			continue
+		}
+
+		c.Pollen = append(c.Pollen, s)
+	}
+
+	return c
+}
+
+// spansYear checks if the history, oldest first, covers at least
+// a full year.
+func spansYear(history []*PollenReport) bool {
+	if len(history) == 0 {
+		return false
+	}
+
+	first := history[0].Date()
+	last := history[len(history)-1].Date()
+	return !first.AddDate(0, 0, minSeasonHistory).After(last)
+}
+
+// computeSeasons averages today's severity of every report in the
+// history per species and ISO week. A season lasts from the first
+// to the last week reaching the threshold, and peaks in the week
+// with the highest mean. Species which never reach the threshold
+// have no season.
+func computeSeasons(history []*PollenReport) map[string]*seasonWeeks {
+	type week struct {
+		sum   float64
+		count int
+	}
+	weeks := make(map[string]map[int]*week)
+
+	for _, r := range history {
+		_, w := r.Date().ISOWeek()
+
+		for _, p := range r.Pollen {
+			if p.Today == nil {
+				continue
+			}
+			level, ok := severityLevel(p.Today.Severity)
+			if !ok {
+				continue
+			}
+
+			if weeks[p.Slug] == nil {
+				weeks[p.Slug] = make(map[int]*week)
+			}
+			if weeks[p.Slug][w] == nil {
+				weeks[p.Slug][w] = &week{}
+			}
+			weeks[p.Slug][w].sum += level
+			weeks[p.Slug][w].count++
+		}
+	}
+
+	seasons := make(map[string]*seasonWeeks)
+	for slug, byWeek := range weeks {
+		var s *seasonWeeks
+		var peak float64
+
+		for w := 1; w <= 53; w++ {
+			stats, ok := byWeek[w]
+			if !ok {
+				continue
+			}
+
+			mean := stats.sum / float64(stats.count)
+			if mean < seasonThreshold {
+				continue
+			}
+
+			if s == nil {
+				s = &seasonWeeks{Start: w}
+			}
+			s.End = w
+			if mean > peak {
+				s.Peak = w
+				peak = mean
+			}
+		}
+
+		if s != nil {
+			seasons[slug] = s
+		}
+	}
+
+	return seasons
+}
+
+func (s *server) handleGetSeasonCalendar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, suggestions, err := s.lookupSubregion(subregionParam(r))
+		if err != nil {
+			if err == ErrNotFound {
+				respondNotFound(w, suggestions)
+				return
+			}
+
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		// Without a history, there is still the static calendar.
+		var history []*PollenReport
+		if s.history != nil {
+			since := current.Date().Add(-historyRetention)
+			history, err = s.history.History(current.Key(), since)
+			if err != nil {
+				log.Printf("[routes] unable to load history: %q", err.Error())
+				respond(w, http.StatusInternalServerError, nil)
+				return
+			}
+		}
+
+		respond(w, http.StatusOK, buildSeasonCalendar(current, history))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// seasonHistory returns a daily report for a whole year. Birke
+// flies from week 14 to 18 and peaks in week 16, hasel never does.
+func seasonHistory() []*PollenReport {
+	var history []*PollenReport
+
+	start := time.Date(2019, 1, 1, 11, 0, 0, 0, dwdLocation)
+	for d := start; !d.After(start.AddDate(1, 0, 0)); d = d.AddDate(0, 0, 1) {
+		birke := "0"
+		switch _, w := d.ISOWeek(); {
+		case w == 16:
+			birke = "3"
+		case w >= 14 && w <= 18:
+			birke = "1-2"
+		}
+
+		history = append(history, &PollenReport{
+			Region:     "region-a",
+			SubRegion:  "subregion-aa",
+			LastUpdate: d,
+			Pollen: []*pollen{
+				{Name: "Birke", Slug: "birke", Today: &pollenDayReport{birke, severityMap[birke]}},
+				{Name: "Hasel", Slug: "hasel", Today: &pollenDayReport{"0", severityMap["0"]}},
+			},
+		})
+	}
+
+	return history
+}
+
+func TestBuildSeasonCalendar(t *testing.T) {
+	history := seasonHistory()
+	current := history[len(history)-1]
+	current.Pollen = append(current.Pollen, &pollen{Name: "Unbekannt", Slug: "unbekannt"})
+
+	got := buildSeasonCalendar(current, history)
+	want := &seasonCalendar{
+		Region:    "region-a",
+		SubRegion: "subregion-aa",
+		Pollen: []*season{
+			{Name: "Birke", Slug: "birke", seasonWeeks: seasonWeeks{14, 16, 18}, Basis: basisHistory},
+			{Name: "Hasel", Slug: "hasel", seasonWeeks: *staticSeasons["hasel"], Basis: basisStatic},
+		},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(season{})); diff != "" {
+		t.Error(diff)
+	}
+
+	t.Run("less than a year of history", func(t *testing.T) {
+		got := buildSeasonCalendar(current, history[100:])
+		for _, s := range got.Pollen {
+			if s.Basis != basisStatic {
+				t.Errorf("expected static season for %s, got %+v", s.Slug, s)
+			}
+		}
+	})
+}
+
+func TestSeasonCalendarEndpoint(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+
+	history := seasonHistory()
+	for _, r := range history {
+		if err := storage.SaveHistory(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Save(history[len(history)-1]); err != nil {
+		t.Fatal(err)
+	}
+
+	s := createServer()
+	s.storage = storage
+
+	testCases := []struct {
+		description string
+		history     HistoryStorage
+		url         string
+		status      int
+		basis       string
+	}{
+		{"without history", nil, "/calendar/subregion-aa", http.StatusOK, basisStatic},
+		{"with history", storage, "/calendar/subregion-aa", http.StatusOK, basisHistory},
+		{"unknown subregion", storage, "/calendar/nope", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			s.history = tc.history
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+
+			if w.Code != tc.status {
+				t.Fatalf("expected %d, got %d", tc.status, w.Code)
+			}
+			if tc.basis == "" {
+				return
+			}
+
+			var c seasonCalendar
+			if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+				t.Fatal(err)
+			}
+			if len(c.Pollen) != 2 || c.Pollen[0].Basis != tc.basis {
+				t.Errorf("expected birke from %s, got %+v", tc.basis, c.Pollen)
+			}
+		})
+	}
+}
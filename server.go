@@ -2,15 +2,92 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// writeTimeout is the time a handler has to write its response,
+// unless the response is a stream.
+const writeTimeout = 10 * time.Second
+
 type server struct {
-	router  *mux.Router
+	router *mux.Router
+	// streams contains the routes which stream their response.
+	streams map[*mux.Route]bool
 	storage Storage
+	// syncers contains one syncer per source. It is empty if
+	// this instance doesn't sync.
+	syncers []*Syncer
+
+	// subscriptions is nil if the storage doesn't
+	// support webhook subscriptions.
+	subscriptions SubscriptionStorage
+
+	// changes is nil if the storage doesn't support
+	// change detection.
+	changes ChangeStorage
+
+	// history is nil if the storage doesn't keep
+	// previous forecasts.
+	history HistoryStorage
+
+	// aliases maps alternative names to subregions. The
+	// bundled aliases are used if it is nil.
+	aliases map[string]string
+
+	// hub is nil if streaming updates is disabled.
+	hub *streamHub
+
+	// search is nil if places can't be searched.
+	search *searchIndex
+
+	// cache is nil if responses don't get cached.
+	cache *responseCache
+
+	// fallback is nil if there is no snapshot to serve while
+	// the storage is unreachable. Otherwise it is the storage.
+	fallback *FallbackStorage
+
+	// access is nil if neither API keys nor rate limits
+	// are enforced.
+	access  *accessConfig
+	apiKeys APIKeyStorage
+	limiter RateLimiter
+
+	// adminToken protects the /admin endpoints. If it is
+	// empty, the admin API is disabled.
+	adminToken string
 }
 
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.router.ServeHTTP(w, r)
 }
+
+// limitWriteTime limits the time it takes to write a response to
+// writeTimeout. Streams registered via streamFunc are long lived
+// and exempt from the limit.
+func (s *server) limitWriteTime(next http.Handler) http.Handler {
+	th := http.TimeoutHandler(next, writeTimeout, "")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.streams[mux.CurrentRoute(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		th.ServeHTTP(w, r)
+	})
+}
+
+// streamFunc registers a route whose response is a long lived
+// stream, so it doesn't time out.
+func (s *server) streamFunc(path string, f http.HandlerFunc) *mux.Route {
+	route := s.router.HandleFunc(path, f)
+
+	if s.streams == nil {
+		s.streams = make(map[*mux.Route]bool)
+	}
+	s.streams[route] = true
+
+	return route
+}
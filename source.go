@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	sourceDWD = "dwd"
+
+	formatDWD = "dwd"
+	// formatCSVFeed is the CSV format we export, so any provider
+	// that can produce a spreadsheet can feed us data.
+	formatCSVFeed = "csv"
+	// formatMeteoSwiss and formatPollenwarndienst are the
+	// forecasts of the Swiss and the Austrian services.
+	formatMeteoSwiss       = "meteoswiss"
+	formatPollenwarndienst = "pollenwarndienst"
+)
+
+// Source turns the data published by a pollen provider into
+// reports. Where the data gets fetched from is up to the upstream
+// the source gets synced from.
+type Source interface {
+	// Name identifies the source. It gets stored with every
+	// report, so clients can tell the providers apart.
+	Name() string
+	// Map decodes and validates a payload. Invalid payloads
+	// result in an error and must not be saved. If the payload
+	// could be decoded, the data is returned even then, so the
+	// sync run can tell which update was rejected.
+	Map(payload []byte) (*sourceData, error)
+}
+
+// sourceData contains the reports of a single payload.
+type sourceData struct {
+	// LastUpdate is the time the provider published the data,
+	// as it appears in the payload.
+	LastUpdate string
+	Reports    []*PollenReport
+}
+
+// sourceFormats contains all formats sources can be configured
+// with. Adding a provider means adding its format here.
+var sourceFormats = map[string]func(name string) Source{
+	formatDWD: func(name string) Source {
+		return &dwdSource{name: name, minLocations: expectedLocations}
+	},
+	formatCSVFeed: func(name string) Source {
+		return &csvSource{name: name}
+	},
+	formatMeteoSwiss: func(name string) Source {
+		return &meteoSwissSource{name: name}
+	},
+	formatPollenwarndienst: func(name string) Source {
+		return &pollenwarndienstSource{name: name}
+	},
+}
+
+// NewEnvSyncers returns a syncer for every source configured via
+// environment variables. SOURCES lists the names of the sources,
+// by default only the DWD is used.
+func NewEnvSyncers(s Storage) ([]*Syncer, error) {
+	names := []string{sourceDWD}
+	if v, exists := os.LookupEnv("SOURCES"); exists {
+		names = splitList(strings.ToLower(v))
+	}
+
+	if len(names) == 0 {
+		return nil, errors.New("SOURCES doesn't contain any sources")
+	}
+
+	var syncers []*Syncer
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			return nil, errors.Errorf("source %q is configured more than once", name)
+		}
+		seen[name] = true
+
+		syncer, err := newEnvSourceSyncer(s, name)
+		if err != nil {
+			return nil, err
+		}
+		syncers = append(syncers, syncer)
+	}
+
+	return syncers, nil
+}
+
+// newEnvSourceSyncer returns a syncer for the named source. Every
+// source is configured with variables prefixed with its name, e.g.
+// DWD_SOURCE and DWD_INTERVAL. Sources named after a format use
+// that format, all others default to the CSV format. Sources other
+// than the DWD need a location.
+func newEnvSourceSyncer(s Storage, name string) (*Syncer, error) {
+	prefix := strings.ToUpper(strings.Replace(name, "-", "_", -1)) + "_"
+
+	format := formatCSVFeed
+	if _, ok := sourceFormats[name]; ok {
+		format = name
+	}
+	if v, exists := os.LookupEnv(prefix + "FORMAT"); exists {
+		format = strings.ToLower(v)
+	}
+
+	newSource, ok := sourceFormats[format]
+	if !ok {
+		return nil, errors.Errorf("unknown format %q for source %q", format, name)
+	}
+
+	location, exists := os.LookupEnv(prefix + "SOURCE")
+	if !exists {
+		if name != sourceDWD {
+			return nil, errors.Errorf("%sSOURCE is required for source %q", prefix, name)
+		}
+		location = dataURL
+	}
+	replay, _ := strconv.ParseBool(os.Getenv(prefix + "REPLAY"))
+
+	interval := 1 * time.Hour
+	for _, key := range []string{"SYNC_INTERVAL", prefix + "INTERVAL"} {
+		v, exists := os.LookupEnv(key)
+		if !exists {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", key)
+		}
+		interval = d
+	}
+
+	u, err := newUpstream(location, replay)
+	if err != nil {
+		return nil, err
+	}
+
+	syncer := NewUpstreamSyncer(s, u, interval)
+	syncer.source = newSource(name)
+	syncer.quarantineDir = os.Getenv("SYNC_QUARANTINE_DIR")
+
+	return syncer, nil
+}
+
+// filterSource returns the reports published by the source. All
+// reports are returned if source is empty.
+func filterSource(rs []*PollenReport, source string) []*PollenReport {
+	if source == "" {
+		return rs
+	}
+
+	filtered := []*PollenReport{}
+	for _, r := range rs {
+		if r.source() == strings.ToLower(source) {
+			filtered = append(filtered, r)
+		}
+	}
+
+	return filtered
+}
+
+// sourceKey returns the key of a location of the source. Locations
+// of the DWD aren't prefixed, so the keys from before there were
+// multiple sources stay valid.
+func sourceKey(source, location string) string {
+	location = normalizeString(location)
+	if source = strings.ToLower(source); source == "" || source == sourceDWD {
+		return location
+	}
+	return normalizeString(source) + ":" + location
+}
+
+// subregionParam returns the subregion requested in the URL. If
+// the request asks for a source, the subregion is looked up among
+// the locations of that source.
+func subregionParam(r *http.Request) string {
+	subregion := mux.Vars(r)["subregion"]
+	if source := r.URL.Query().Get("source"); source != "" {
+		return sourceKey(source, subregion)
+	}
+	return subregion
+}
+
+// source returns the name of the source the report came from.
+// Reports saved before there were multiple sources are from
+// the DWD.
+func (r *PollenReport) source() string {
+	if r.Source == "" {
+		return sourceDWD
+	}
+	return r.Source
+}
+
+// dwdSource reads the s31fg.json published by the DWD.
+type dwdSource struct {
+	name string
+	// minLocations is the minimum number of locations a valid
+	// response has to contain. Zero disables the check.
+	minLocations int
+}
+
+func (s *dwdSource) Name() string {
+	return s.name
+}
+
+func (s *dwdSource) Map(payload []byte) (*sourceData, error) {
+	var data openDataPollenResponse
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, errors.Wrap(err, "unable to decode response")
+	}
+
+	result := &sourceData{LastUpdate: data.LastUpdate}
+
+	if err := validateResponse(&data, s.minLocations); err != nil {
+		return result, err
+	}
+
+	result.Reports = mapResponse(&data)
+	return result, nil
+}
+
+// csvColumns are the columns a CSV feed needs to have, in any
+// order. It can contain other columns, which get ignored. This
+// is a subset of the columns of our own CSV export.
+var csvColumns = []string{"region", "sub_region", "last_update", "species", "day", "severity"}
+
+// csvSource reads a CSV file with one row per location, species
+// and day. The severities use the same scale as the DWD.
+type csvSource struct {
+	name string
+}
+
+func (s *csvSource) Name() string {
+	return s.name
+}
+
+func (s *csvSource) Map(payload []byte) (*sourceData, error) {
+	r := csv.NewReader(bytes.NewReader(payload))
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read csv header")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.Errorf("csv feed is missing the %s column", name)
+		}
+	}
+
+	var (
+		problems   []string
+		reports    []*PollenReport
+		byLocation = make(map[string]*PollenReport)
+		lastUpdate time.Time
+	)
+	addProblem := func(line int, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("line %d: ", line)+fmt.Sprintf(format, args...))
+	}
+
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to decode csv feed")
+		}
+
+		value := func(column string) string {
+			if i := columns[column]; i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		region, subregion := value("region"), value("sub_region")
+		if region == "" {
+			addProblem(line, "no region name")
+			continue
+		}
+
+		updated, err := time.Parse(time.RFC3339, value("last_update"))
+		if err != nil {
+			addProblem(line, "invalid last_update %q", value("last_update"))
+			continue
+		}
+		if updated.After(lastUpdate) {
+			lastUpdate = updated
+		}
+
+		severity := value("severity")
+		if _, ok := severityLevel(severity); !ok {
+			addProblem(line, "unknown severity %q", severity)
+			continue
+		}
+
+		key := region + "/" + subregion
+		report, ok := byLocation[key]
+		if !ok {
+			report = &PollenReport{Region: region, SubRegion: subregion, LastUpdate: updated}
+			byLocation[key] = report
+			reports = append(reports, report)
+		}
+		if updated.After(report.LastUpdate) {
+			report.LastUpdate = updated
+		}
+
+		p := sourceSpecies(report, value("species"))
+		if p == nil {
+			addProblem(line, "no species")
+			continue
+		}
+
+		day := &pollenDayReport{severity, severityMap[severity]}
+		switch value("day") {
+		case dayToday:
+			if p.Today != nil {
+				addProblem(line, "%s: %s appears more than once for today", key, p.Name)
+			}
+			p.Today = day
+		case dayTomorrow:
+			if p.Tomorrow != nil {
+				addProblem(line, "%s: %s appears more than once for tomorrow", key, p.Name)
+			}
+			p.Tomorrow = day
+		case dayDayAfterTomorrow:
+			if p.DayAfterTomorrow != nil {
+				addProblem(line, "%s: %s appears more than once for day_after_tomorrow", key, p.Name)
+			}
+			p.DayAfterTomorrow = day
+		default:
+			addProblem(line, "unknown day %q", value("day"))
+		}
+	}
+
+	if len(reports) == 0 && len(problems) == 0 {
+		problems = append(problems, "feed contains no rows")
+	}
+
+	// Everything else expects all three days to be there.
+	for _, r := range reports {
+		for _, p := range r.Pollen {
+			for _, d := range p.days() {
+				if d.report == nil {
+					problems = append(problems, fmt.Sprintf("%s/%s: no data for %s %s", r.Region, r.SubRegion, p.Name, d.name))
+				}
+			}
+		}
+
+		sort.Slice(r.Pollen, func(i, j int) bool {
+			return r.Pollen[i].Slug < r.Pollen[j].Slug
+		})
+	}
+
+	result := &sourceData{}
+	if !lastUpdate.IsZero() {
+		result.LastUpdate = lastUpdate.Format(time.RFC3339)
+	}
+
+	if len(problems) > 0 {
+		return result, &ValidationError{problems}
+	}
+
+	result.Reports = reports
+	return result, nil
+}
+
+// sourceSpecies returns the report's entry for the species,
+// adding it if necessary. Species can be given by slug or name.
+func sourceSpecies(r *PollenReport, name string) *pollen {
+	if name == "" {
+		return nil
+	}
+
+	sp, ok := lookupSpeciesBySlug(name)
+	if !ok {
+		sp = lookupSpecies(name)
+	}
+
+	if p := r.species(sp.Slug); p != nil {
+		return p
+	}
+
+	p := &pollen{Name: sp.Name, Slug: sp.Slug, LatinName: sp.LatinName}
+	r.Pollen = append(r.Pollen, p)
+	return p
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func csvFeed(rows ...string) []byte {
+	return []byte(strings.Join(append([]string{"region,sub_region,last_update,species,day,severity"}, rows...), "\n"))
+}
+
+func TestCSVSource(t *testing.T) {
+	lastUpdate := time.Date(2020, 4, 1, 9, 0, 0, 0, time.UTC)
+
+	t.Run("valid feed", func(t *testing.T) {
+		payload := csvFeed(
+			"Tirol,Innsbruck,2020-04-01T09:00:00Z,birke,today,2",
+			"Tirol,Innsbruck,2020-04-01T09:00:00Z,birke,tomorrow,2-3",
+			"Tirol,Innsbruck,2020-04-01T09:00:00Z,Birke,day_after_tomorrow,3",
+		)
+
+		data, err := (&csvSource{name: "austria"}).Map(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &sourceData{
+			LastUpdate: "2020-04-01T09:00:00Z",
+			Reports: []*PollenReport{
+				{
+					Region:     "Tirol",
+					SubRegion:  "Innsbruck",
+					LastUpdate: lastUpdate,
+					Pollen: []*pollen{
+						{
+							Name:             "Birke",
+							Slug:             "birke",
+							LatinName:        "Betula",
+							Today:            &pollenDayReport{"2", "mittlere Belastung"},
+							Tomorrow:         &pollenDayReport{"2-3", "mittlere bis hohe Belastung"},
+							DayAfterTomorrow: &pollenDayReport{"3", "hohe Belastung"},
+						},
+					},
+				},
+			},
+		}
+		if diff := cmp.Diff(want, data, cmp.AllowUnexported(sourceData{})); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	testCases := map[string]struct {
+		payload  []byte
+		problems int
+	}{
+		"unknown severity": {
+			csvFeed(
+				"Tirol,,2020-04-01T09:00:00Z,birke,today,viel",
+				"Tirol,,2020-04-01T09:00:00Z,birke,tomorrow,1",
+				"Tirol,,2020-04-01T09:00:00Z,birke,day_after_tomorrow,1",
+			),
+			2,
+		},
+		"missing day": {
+			csvFeed(
+				"Tirol,,2020-04-01T09:00:00Z,birke,today,1",
+				"Tirol,,2020-04-01T09:00:00Z,birke,tomorrow,1",
+			),
+			1,
+		},
+		"duplicate row": {
+			csvFeed(
+				"Tirol,,2020-04-01T09:00:00Z,birke,today,1",
+				"Tirol,,2020-04-01T09:00:00Z,birke,today,1",
+				"Tirol,,2020-04-01T09:00:00Z,birke,tomorrow,1",
+				"Tirol,,2020-04-01T09:00:00Z,birke,day_after_tomorrow,1",
+			),
+			1,
+		},
+		"invalid last update": {
+			csvFeed("Tirol,,gestern,birke,today,1"),
+			1,
+		},
+		"empty feed": {
+			csvFeed(),
+			1,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := (&csvSource{name: "austria"}).Map(tc.payload)
+
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+			if len(verr.Problems) != tc.problems {
+				t.Errorf("expected %d problems, got %q", tc.problems, verr.Problems)
+			}
+		})
+	}
+
+	t.Run("missing column", func(t *testing.T) {
+		if _, err := (&csvSource{name: "austria"}).Map([]byte("region,species\nTirol,birke")); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestNewEnvSyncers(t *testing.T) {
+	defer os.Unsetenv("SOURCES")
+	defer os.Unsetenv("AUSTRIA_SOURCE")
+	defer os.Unsetenv("AUSTRIA_INTERVAL")
+
+	syncers, err := NewEnvSyncers(&inMemoryStorage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(syncers) != 1 || syncers[0].dataSource().Name() != sourceDWD {
+		t.Errorf("expected only the DWD by default, got %+v", syncers)
+	}
+
+	os.Setenv("SOURCES", "dwd,austria")
+	if _, err := NewEnvSyncers(&inMemoryStorage{}); err == nil {
+		t.Error("expected an error for a source without location")
+	}
+
+	os.Setenv("AUSTRIA_SOURCE", "https://example.com/pollen.csv")
+	os.Setenv("AUSTRIA_INTERVAL", "3h")
+	syncers, err = NewEnvSyncers(&inMemoryStorage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(syncers) != 2 {
+		t.Fatalf("expected two syncers, got %d", len(syncers))
+	}
+
+	austria := syncers[1]
+	if _, ok := austria.source.(*csvSource); !ok || austria.dataSource().Name() != "austria" || austria.interval != 3*time.Hour {
+		t.Errorf("expected austria to be a csv feed synced every 3 hours, got %+v", austria)
+	}
+	if syncers[0].interval != time.Hour {
+		t.Errorf("expected the DWD to keep its interval, got %s", syncers[0].interval)
+	}
+}
+
+func TestSourceFilter(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(csvFeed(
+			"Tirol,Innsbruck,2020-04-01T09:00:00Z,birke,today,2",
+			"Tirol,Innsbruck,2020-04-01T09:00:00Z,birke,tomorrow,2",
+			"Tirol,Innsbruck,2020-04-01T09:00:00Z,birke,day_after_tomorrow,2",
+		))
+	}))
+	defer feed.Close()
+
+	syncer := &Syncer{
+		storage:  storage,
+		upstream: &httpUpstream{feed.URL},
+		source:   &csvSource{name: "austria"},
+	}
+	if run := syncer.Sync(triggerManual); run.Error != "" || run.Source != "austria" {
+		t.Fatalf("sync failed: %+v", run)
+	}
+
+	s := createServer()
+	s.storage = storage
+
+	testCases := []struct {
+		url      string
+		expected int
+	}{
+		{"/pollen", 5},
+		{"/pollen?source=austria", 1},
+		{"/pollen?source=DWD", 4},
+		{"/pollen/region/Tirol?source=dwd", 0},
+	}
+
+	for _, tc := range testCases {
+		r := httptest.NewRequest("GET", tc.url, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		var rs []*PollenReport
+		if err := json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&rs); err != nil {
+			t.Fatalf("%s: unable to decode response: %q", tc.url, err)
+		}
+		if len(rs) != tc.expected {
+			t.Errorf("%s: expected %d reports, got %d", tc.url, tc.expected, len(rs))
+		}
+		for _, r := range rs {
+			if tc.url == "/pollen?source=austria" && r.Source != "austria" {
+				t.Errorf("expected report from austria, got %q", r.Source)
+			}
+		}
+	}
+}
+
+func TestSourcesDontShareLocations(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(csvFeed(
+			"region-a,subregion-aa,2020-04-01T09:00:00Z,birke,today,3",
+			"region-a,subregion-aa,2020-04-01T09:00:00Z,birke,tomorrow,3",
+			"region-a,subregion-aa,2020-04-01T09:00:00Z,birke,day_after_tomorrow,3",
+		))
+	}))
+	defer feed.Close()
+
+	observer := &recordingObserver{}
+	syncer := &Syncer{
+		storage:  storage,
+		upstream: &httpUpstream{feed.URL},
+		source:   &csvSource{name: "austria"},
+	}
+	syncer.Observe(observer)
+	if run := syncer.Sync(triggerManual); run.Error != "" || run.Saved != 1 {
+		t.Fatalf("sync failed: %+v", run)
+	}
+
+	if len(observer.updates) != 1 || observer.updates[0].Previous != nil {
+		t.Errorf("expected the report not to be compared with the DWD's, got %+v", observer.updates)
+	}
+
+	s := createServer()
+	s.storage = storage
+
+	testCases := map[string]string{
+		"/pollen/subregion/subregion-aa":                "",
+		"/pollen/subregion/subregion-aa?source=dwd":     "",
+		"/pollen/subregion/subregion-aa?source=austria": "austria",
+		"/pollen/subregion/austria:subregion_aa":        "austria",
+	}
+
+	for url, source := range testCases {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+
+		var report PollenReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected response %d (%v)", url, w.Code, err)
+		}
+		if report.Source != source {
+			t.Errorf("%s: expected report from %q, got %q", url, source, report.Source)
+		}
+	}
+
+	if subregions, _ := storage.AllSubregions(); len(subregions) != 5 {
+		t.Errorf("expected both locations to be listed, got %q", subregions)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Species describes a type of pollen the DWD publishes
+// forecasts for.
+type Species struct {
+	// Key is the name the DWD uses for the species in its data.
+	Key       string `json:"-"`
+	Slug      string `json:"slug"`
+	Name      string `json:"name"`
+	LatinName string `json:"latin_name"`
+}
+
+// speciesRegistry contains all species we know about. Species
+// which show up in the upstream data but aren't listed here are
+// still picked up, they just don't have a latin name.
+var speciesRegistry = []*Species{
+	{"Ambrosia", "ambrosia", "Ambrosia", "Ambrosia artemisiifolia"},
+	{"Beifuss", "beifuss", "Beifuss", "Artemisia vulgaris"},
+	{"Birke", "birke", "Birke", "Betula"},
+	{"Erle", "erle", "Erle", "Alnus"},
+	{"Esche", "esche", "Esche", "Fraxinus"},
+	{"Graeser", "graeser", "Gräser", "Poaceae"},
+	{"Hasel", "hasel", "Hasel", "Corylus"},
+	{"Roggen", "roggen", "Roggen", "Secale cereale"},
+}
+
+var (
+	speciesByKey  = make(map[string]*Species)
+	speciesBySlug = make(map[string]*Species)
+
+	transliterations = strings.NewReplacer(
+		"ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss",
+		"Ä", "ae", "Ö", "oe", "Ü", "ue",
+	)
+)
+
+func init() {
+	for _, s := range speciesRegistry {
+		speciesByKey[s.Key] = s
+		speciesBySlug[s.Slug] = s
+	}
+}
+
+// lookupSpecies returns the species for a key used in the
+// upstream data. Unknown species get a slug derived from
+// their key.
+func lookupSpecies(key string) *Species {
+	if s, ok := speciesByKey[key]; ok {
+		return s
+	}
+
+	return &Species{
+		Key:  key,
+		Slug: slugify(key),
+		Name: strings.TrimSpace(key),
+	}
+}
+
+// lookupSpeciesBySlug returns the registered species with the
+// provided slug.
+func lookupSpeciesBySlug(slug string) (*Species, bool) {
+	s, ok := speciesBySlug[slugify(slug)]
+	return s, ok
+}
+
+// sortedSpeciesKeys returns the keys of the provided map in a
+// stable order.
+func sortedSpeciesKeys(m map[string]*openDataSinglePollenReport) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return lookupSpecies(keys[i]).Slug < lookupSpecies(keys[j]).Slug
+	})
+
+	return keys
+}
+
+// slugify turns s into a lowercase ASCII identifier, replacing
+// umlauts and separating words with dashes.
+func slugify(s string) string {
+	s = transliterations.Replace(strings.ToLower(strings.TrimSpace(s)))
+
+	var b strings.Builder
+	dash := false
+	for _, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Birke", "birke"},
+		{"Gräser", "graeser"},
+		{" Beifuß ", "beifuss"},
+		{"Westl. Niedersachsen/Bremen", "westl-niedersachsen-bremen"},
+		{"Rhein-Main", "rhein-main"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			if got := slugify(tc.in); got != tc.want {
+				t.Errorf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestUnknownSpeciesArePickedUp(t *testing.T) {
+	report := openDataPollenReport{
+		"Birke": &openDataSinglePollenReport{"1", "0", "2"},
+		"Ulme":  &openDataSinglePollenReport{"0", "1", "0"},
+	}
+
+	got := mapLocationReport(report)
+
+	want := []*pollen{
+		{
+			Name:             "Birke",
+			Slug:             "birke",
+			LatinName:        "Betula",
+			Today:            &pollenDayReport{"0", "keine Belastung"},
+			Tomorrow:         &pollenDayReport{"1", "geringe Belastung"},
+			DayAfterTomorrow: &pollenDayReport{"2", "mittlere Belastung"},
+		},
+		{
+			Name:             "Ulme",
+			Slug:             "ulme",
+			Today:            &pollenDayReport{"1", "geringe Belastung"},
+			Tomorrow:         &pollenDayReport{"0", "keine Belastung"},
+			DayAfterTomorrow: &pollenDayReport{"0", "keine Belastung"},
+		},
+	}
+
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v7"
@@ -18,6 +20,9 @@ var (
 	// ErrCouldNotConnectToStorage is returned if we failed to
 	// connect to the configured storage.
 	ErrCouldNotConnectToStorage = errors.New("storage: unable to connect")
+	// ErrUnexpectedScriptResult is returned if a lua script
+	// returned something we didn't expect.
+	ErrUnexpectedScriptResult = errors.New("storage: unexpected script result")
 
 	keyRemoveRegexp  = regexp.MustCompile(`[.,]`)
 	keyReplaceRegexp = regexp.MustCompile(`[/\s-]`)
@@ -32,12 +37,15 @@ type Storage interface {
 	AllReports() ([]*PollenReport, error)
 	GetByRegion(region string) ([]*PollenReport, error)
 	GetBySubregion(subregion string) (*PollenReport, error)
+	// GetBySubregions returns the reports of the subregions in
+	// the same order. Subregions without data are nil.
+	GetBySubregions(subregions []string) ([]*PollenReport, error)
 }
 
 // RedisStorage is a storage that reads and writes to a
 // safe reads and writes.
 type RedisStorage struct {
-	client *redis.Client
+	client redis.UniversalClient
 
 	// prefix gets prepended to every key
 	prefix string
@@ -47,34 +55,53 @@ type RedisStorage struct {
 // variables. If the required variables are not set, it attempts
 // to use sensible defaults.
 func NewEnvStorage() (Storage, error) {
-	addr, exists := os.LookupEnv("REDIS_HOST")
-	if !exists {
-		addr = "localhost:6379"
+	rs, err := newEnvRedisStorage(true)
+	if err != nil {
+		return nil, err
 	}
+	return rs, nil
+}
+
+// newEnvRedisStorage creates the storage configured via environment
+// variables. Unless check is set, it doesn't fail if redis can't be
+// reached. The client connects as soon as redis is up.
+func newEnvRedisStorage(check bool) (*RedisStorage, error) {
+	c, err := newEnvRedisConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	prefix, exists := os.LookupEnv("REDIS_KEY_PREFIX")
 	if !exists {
 		prefix = ""
 	}
-	password, exists := os.LookupEnv("REDIS_PASSWORD")
-	if !exists {
-		password = ""
-	}
-	return NewRedisStorage(addr, password, prefix, 5*time.Second)
+	return newRedisStorage(c, prefix, check)
 }
 
 // NewRedisStorage creates a new storage which reads and writes
 // to the redis server located at the provided addr.
 func NewRedisStorage(addr, password, prefix string, dialTimeout time.Duration) (*RedisStorage, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:        addr,
-		Password:    password,
-		DB:          0,
-		DialTimeout: dialTimeout,
-	})
+	return newRedisStorage(&redisConfig{
+		options: &redis.UniversalOptions{
+			Addrs:       []string{addr},
+			Password:    password,
+			DialTimeout: dialTimeout,
+		},
+	}, prefix, true)
+}
 
-	_, err := client.Ping().Result()
-	if err != nil {
-		return nil, ErrCouldNotConnectToStorage
+func newRedisStorage(c *redisConfig, prefix string, check bool) (*RedisStorage, error) {
+	client := c.newClient()
+
+	if check {
+		if _, err := client.Ping().Result(); err != nil {
+			client.Close()
+			return nil, ErrCouldNotConnectToStorage
+		}
+	}
+
+	if c.cluster {
+		prefix = clusterPrefix(prefix)
 	}
 
 	return &RedisStorage{
@@ -94,15 +121,10 @@ func (rs *RedisStorage) Save(r *PollenReport) error {
 	}
 
 	normalizedRegion := normalizeString(r.Region)
-	normalizedSubregion := normalizeString(r.SubRegion)
 
-	key := normalizedSubregion
-	// Not all regions have sub regions. In this case, we want to
-	// use the region name as the key instead
-	if key == "" {
-		key = normalizedRegion
-	}
-	key = rs.makeKey("report:" + key)
+	// Not all regions have sub regions. In this case, the
+	// region name is used as the key instead
+	key := rs.makeKey("report:" + r.Key())
 	rs.client.Set(key, json, 0)
 
 	// Add the region to the reports set so we can later fetch
@@ -118,11 +140,7 @@ func (rs *RedisStorage) Save(r *PollenReport) error {
 	// result instead of having to fetch all reports for the region
 	// which would always result in an array of length 1. And that
 	// is annoying to deal with.
-	if r.SubRegion != "" {
-		rs.client.SAdd(rs.makeKey("subregions"), normalizedSubregion)
-	} else {
-		rs.client.SAdd(rs.makeKey("subregions"), normalizedRegion)
-	}
+	rs.client.SAdd(rs.makeKey("subregions"), r.Key())
 
 	// Tag this report with this region so we can easily look up
 	// all reports for a region
@@ -142,6 +160,11 @@ func (rs *RedisStorage) AllReports() ([]*PollenReport, error) {
 		return nil, err
 	}
 
+	// MGET fails without any keys
+	if len(keys) == 0 {
+		return []*PollenReport{}, nil
+	}
+
 	vals, err := rs.client.MGet(keys...).Result()
 	if err != nil {
 		log.Printf("[storage] couldn't fetch reports: %s", err)
@@ -186,6 +209,41 @@ func (rs *RedisStorage) GetBySubregion(subregion string) (*PollenReport, error)
 	return &pr, nil
 }
 
+// GetBySubregions loads the reports of several subregions with
+// a single MGET. Subregions without data are nil.
+func (rs *RedisStorage) GetBySubregions(subregions []string) ([]*PollenReport, error) {
+	// MGET fails without any keys
+	if len(subregions) == 0 {
+		return []*PollenReport{}, nil
+	}
+
+	keys := make([]string, len(subregions))
+	for i, subregion := range subregions {
+		keys[i] = rs.makeKey("report:" + subregion)
+	}
+
+	vals, err := rs.client.MGet(keys...).Result()
+	if err != nil {
+		log.Printf("[storage] couldn't fetch reports: %q", err.Error())
+		return nil, err
+	}
+
+	reports := make([]*PollenReport, len(keys))
+	for i, v := range vals {
+		if v == nil {
+			continue
+		}
+
+		r, err := parseReport(v)
+		if err != nil {
+			return nil, err
+		}
+		reports[i] = r
+	}
+
+	return reports, nil
+}
+
 // GetByRegion returns the pollen reports of all subregions
 // of the provided region.
 func (rs *RedisStorage) GetByRegion(region string) ([]*PollenReport, error) {
@@ -198,6 +256,10 @@ func (rs *RedisStorage) GetByRegion(region string) ([]*PollenReport, error) {
 		return nil, err
 	}
 
+	if len(reportKeys) == 0 {
+		return nil, ErrNotFound
+	}
+
 	reportVals, err := rs.client.MGet(reportKeys...).Result()
 	if err != nil {
 		log.Printf("[storage] couldn't fetch reports: %q", err)
@@ -228,6 +290,326 @@ func (rs *RedisStorage) AllSubregions() ([]string, error) {
 	return rs.client.SMembers(rs.makeKey("subregions")).Result()
 }
 
+// SaveSubscription writes the subscription to redis and adds it
+// to the subscriptions of its subregion.
+func (rs *RedisStorage) SaveSubscription(s *Subscription) error {
+	json, err := json.Marshal(s)
+	if err != nil {
+		log.Printf("[storage] unable to marshal subscription: %q", err.Error())
+		return err
+	}
+
+	if err := rs.client.Set(rs.makeKey("subscription:"+s.ID), json, 0).Err(); err != nil {
+		return err
+	}
+
+	return rs.client.SAdd(rs.subscriptionsKey(s.Subregion), s.ID).Err()
+}
+
+// GetSubscription loads the subscription with the provided id.
+// If it doesn't exist, it returns ErrNotFound.
+func (rs *RedisStorage) GetSubscription(id string) (*Subscription, error) {
+	strValue, err := rs.client.Get(rs.makeKey("subscription:" + id)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var s Subscription
+	if err := json.Unmarshal([]byte(strValue), &s); err != nil {
+		log.Printf("[storage] unable to unmarshal subscription: %q", err.Error())
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// DeleteSubscription removes a subscription and its delivery
+// log. If it doesn't exist, it returns ErrNotFound.
+func (rs *RedisStorage) DeleteSubscription(id string) error {
+	s, err := rs.GetSubscription(id)
+	if err != nil {
+		return err
+	}
+
+	rs.client.SRem(rs.subscriptionsKey(s.Subregion), id)
+	return rs.client.Del(
+		rs.makeKey("subscription:"+id),
+		rs.makeKey("subscription:"+id+":deliveries"),
+	).Err()
+}
+
+// SubscriptionsForSubregion returns all subscriptions for the
+// provided subregion.
+func (rs *RedisStorage) SubscriptionsForSubregion(subregion string) ([]*Subscription, error) {
+	ids, err := rs.client.SMembers(rs.subscriptionsKey(subregion)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []*Subscription
+	for _, id := range ids {
+		s, err := rs.GetSubscription(id)
+		if err != nil {
+			if err == ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+
+	return subs, nil
+}
+
+// LogDelivery adds the delivery to the log of its subscription.
+// Only the most recent deliveries are kept.
+func (rs *RedisStorage) LogDelivery(d *Delivery) error {
+	json, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	key := rs.makeKey("subscription:" + d.SubscriptionID + ":deliveries")
+	if err := rs.client.LPush(key, json).Err(); err != nil {
+		return err
+	}
+
+	return rs.client.LTrim(key, 0, maxDeliveries-1).Err()
+}
+
+// Deliveries returns the most recent deliveries of a
+// subscription, newest first.
+func (rs *RedisStorage) Deliveries(subscriptionID string) ([]*Delivery, error) {
+	vals, err := rs.client.LRange(rs.makeKey("subscription:"+subscriptionID+":deliveries"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*Delivery, len(vals))
+	for i, v := range vals {
+		var d Delivery
+		if err := json.Unmarshal([]byte(v), &d); err != nil {
+			return nil, err
+		}
+		deliveries[i] = &d
+	}
+
+	return deliveries, nil
+}
+
+func (rs *RedisStorage) subscriptionsKey(subregion string) string {
+	return rs.makeKey("subregion:" + normalizeString(subregion) + ":subscriptions")
+}
+
+// SaveChanges adds the changes to a sorted set, scored by the
+// time they were detected. Changes older than the retention
+// period get removed.
+func (rs *RedisStorage) SaveChanges(changes []*Change) error {
+	key := rs.makeKey("changes")
+
+	members := make([]*redis.Z, len(changes))
+	for i, c := range changes {
+		json, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		members[i] = &redis.Z{Score: float64(c.DetectedAt.Unix()), Member: json}
+	}
+
+	if err := rs.client.ZAdd(key, members...).Err(); err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-changeRetention).Unix()
+	return rs.client.ZRemRangeByScore(key, "-inf", strconv.FormatInt(cutoff, 10)).Err()
+}
+
+// ChangesSince returns all changes detected at or after since,
+// oldest first.
+func (rs *RedisStorage) ChangesSince(since time.Time) ([]*Change, error) {
+	vals, err := rs.client.ZRangeByScore(rs.makeKey("changes"), &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]*Change, len(vals))
+	for i, v := range vals {
+		var c Change
+		if err := json.Unmarshal([]byte(v), &c); err != nil {
+			return nil, err
+		}
+		changes[i] = &c
+	}
+
+	return changes, nil
+}
+
+// SaveHistory adds the report to the history of its subregion.
+// It replaces an earlier forecast published on the same day.
+// Forecasts older than the retention period, counting back from
+// this one, get removed. This keeps replayed snapshots around.
+func (rs *RedisStorage) SaveHistory(r *PollenReport) error {
+	json, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	key := rs.makeKey("history:" + r.Key())
+	score := strconv.FormatInt(r.Date().Unix(), 10)
+	cutoff := r.Date().Add(-historyRetention).Unix()
+
+	// Several syncers can save the same subregion at once. Inside
+	// a transaction, one can't remove the forecast the other one
+	// just added.
+	_, err = rs.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(key, score, score)
+		pipe.ZAdd(key, &redis.Z{Score: float64(r.Date().Unix()), Member: json})
+		pipe.ZRemRangeByScore(key, "-inf", "("+strconv.FormatInt(cutoff, 10))
+		return nil
+	})
+	return err
+}
+
+// History returns the forecasts for the subregion published on
+// or after since, oldest first.
+func (rs *RedisStorage) History(subregion string, since time.Time) ([]*PollenReport, error) {
+	vals, err := rs.client.ZRangeByScore(rs.makeKey("history:"+normalizeString(subregion)), &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*PollenReport, len(vals))
+	for i, v := range vals {
+		r, err := parseReport(v)
+		if err != nil {
+			return nil, err
+		}
+		reports[i] = r
+	}
+
+	return reports, nil
+}
+
+// SaveAPIKey stores the key under its hash. The key itself
+// never gets written to redis.
+func (rs *RedisStorage) SaveAPIKey(k *APIKey, hash string) error {
+	stored := *k
+	stored.Key = ""
+
+	json, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+
+	if err := rs.client.Set(rs.makeKey("apikey:"+hash), json, 0).Err(); err != nil {
+		return err
+	}
+
+	// Keep track of the hash by id so keys can be managed
+	// without knowing the key itself.
+	return rs.client.HSet(rs.makeKey("apikeys"), k.ID, hash).Err()
+}
+
+// GetAPIKey loads the API key with the provided hash. If it
+// doesn't exist, it returns ErrNotFound.
+func (rs *RedisStorage) GetAPIKey(hash string) (*APIKey, error) {
+	strValue, err := rs.client.Get(rs.makeKey("apikey:" + hash)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var k APIKey
+	if err := json.Unmarshal([]byte(strValue), &k); err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+// DeleteAPIKey removes the API key with the provided id. If it
+// doesn't exist, it returns ErrNotFound.
+func (rs *RedisStorage) DeleteAPIKey(id string) error {
+	hash, err := rs.client.HGet(rs.makeKey("apikeys"), id).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if err := rs.client.Del(rs.makeKey("apikey:" + hash)).Err(); err != nil {
+		return err
+	}
+
+	return rs.client.HDel(rs.makeKey("apikeys"), id).Err()
+}
+
+// AllAPIKeys returns all API keys.
+func (rs *RedisStorage) AllAPIKeys() ([]*APIKey, error) {
+	hashes, err := rs.client.HGetAll(rs.makeKey("apikeys")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []*APIKey{}
+	for _, hash := range hashes {
+		k, err := rs.GetAPIKey(hash)
+		if err != nil {
+			if err == ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// Publish implements UpdateBus. Every instance connected to
+// the same redis server receives the message.
+func (rs *RedisStorage) Publish(msg []byte) error {
+	return rs.client.Publish(rs.makeKey("updates"), msg).Err()
+}
+
+// Subscribe implements UpdateBus. The returned channel receives
+// all published messages until the returned func is called. The
+// subscription gets reestablished if the connection drops.
+func (rs *RedisStorage) Subscribe() (<-chan []byte, func()) {
+	ps := rs.client.Subscribe(rs.makeKey("updates"))
+	ch := make(chan []byte)
+
+	go func() {
+		defer close(ch)
+		for msg := range ps.Channel() {
+			ch <- []byte(msg.Payload)
+		}
+	}()
+
+	return ch, func() { ps.Close() }
+}
+
+// Ping implements pinger.
+func (rs *RedisStorage) Ping() error {
+	return rs.client.Ping().Err()
+}
+
 func (rs *RedisStorage) makeKey(key string) string {
 	key = normalizeString(key)
 	if rs.prefix == "" {
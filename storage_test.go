@@ -54,6 +54,7 @@ func createPollenReport(region, subregion string) *PollenReport {
 		Pollen: []*pollen{
 			{
 				Name: "Roggen",
+				Slug: "roggen",
 				Today: &pollenDayReport{
 					Description: "mittlere Belastung",
 					Severity:    "2",
@@ -158,6 +159,27 @@ func TestFetchBySubregion(t *testing.T) {
 	}
 }
 
+func TestFetchBySubregions(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	s := newStorage(mr)
+
+	got, err := s.GetBySubregions([]string{"subregion-ba", "nope", "region-c"})
+	if err != nil {
+		t.Fatalf("tried to fetch reports for subregions, got error instead: %q", err)
+	}
+
+	want := []*PollenReport{regionBSubRegionA, nil, regionCNoSubregion}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+
+	got, err = s.GetBySubregions(nil)
+	if err != nil || len(got) != 0 {
+		t.Errorf("expected no reports without subregions, got %d %v", len(got), err)
+	}
+}
+
 func TestGetAllRegions(t *testing.T) {
 	mr := newMiniRedisServer()
 	defer mr.Close()
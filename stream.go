@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// streamClientBuffer is the number of events buffered per
+	// client. Clients which fall further behind miss events.
+	streamClientBuffer = 32
+
+	streamKeepAlive = 15 * time.Second
+
+	// localBusBuffer is the number of messages buffered per
+	// subscriber of the local bus. Subscribers which fall further
+	// behind miss messages.
+	localBusBuffer = 16
+)
+
+// UpdateBus distributes updated reports between all running
+// instances of the server.
+type UpdateBus interface {
+	Publish(msg []byte) error
+	Subscribe() (<-chan []byte, func())
+}
+
+// updateMessage is sent over the UpdateBus after a sync
+// updated at least one report.
+type updateMessage struct {
+	Reports []*PollenReport `json:"reports"`
+}
+
+// updatePublisher publishes all reports which were updated by
+// a sync to the UpdateBus. Reports with a new last update are
+// published even if the forecast is the same, so other instances
+// don't keep serving the old dates.
+type updatePublisher struct {
+	bus UpdateBus
+}
+
+// ReportsSynced implements SyncObserver.
+func (p *updatePublisher) ReportsSynced(updates []*ReportUpdate) {
+	var msg updateMessage
+	for _, u := range updates {
+		if u.Updated() {
+			msg.Reports = append(msg.Reports, u.Current)
+		}
+	}
+
+	if len(msg.Reports) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(&msg)
+	if err != nil {
+		log.Printf("[stream] unable to marshal update: %q", err.Error())
+		return
+	}
+
+	if err := p.bus.Publish(data); err != nil {
+		log.Printf("[stream] unable to publish update: %q", err.Error())
+	}
+}
+
+// Updated returns whether the report differs from the previous
+// one, either in its forecast or in its last update.
+func (u *ReportUpdate) Updated() bool {
+	return u.Changed() || !u.Previous.LastUpdate.Equal(u.Current.LastUpdate)
+}
+
+// Changed returns whether the forecast differs from the
+// previous one.
+func (u *ReportUpdate) Changed() bool {
+	if u.Previous == nil {
+		return true
+	}
+	return !reflect.DeepEqual(u.Previous.Pollen, u.Current.Pollen)
+}
+
+// localBus is an UpdateBus which only works within a single
+// process. It is used if the storage doesn't provide a bus.
+type localBus struct {
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+}
+
+func newLocalBus() *localBus {
+	return &localBus{subscribers: make(map[chan []byte]struct{})}
+}
+
+// Publish never blocks, so a slow subscriber can't hold up the
+// syncer or any of the other subscribers.
+func (b *localBus) Publish(msg []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			log.Printf("[stream] dropping update for slow subscriber")
+		}
+	}
+
+	return nil
+}
+
+func (b *localBus) Subscribe() (<-chan []byte, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan []byte, localBusBuffer)
+	b.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// streamHub fans out the updates received over the UpdateBus
+// to all clients connected to this instance.
+type streamHub struct {
+	mu      sync.Mutex
+	clients map[*streamClient]struct{}
+}
+
+type streamClient struct {
+	region    string
+	subregion string
+	events    chan []byte
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{clients: make(map[*streamClient]struct{})}
+}
+
+// listen broadcasts every message received over the bus until
+// the bus gets closed.
+func (h *streamHub) listen(bus UpdateBus) {
+	ch, _ := bus.Subscribe()
+	for msg := range ch {
+		var update updateMessage
+		if err := json.Unmarshal(msg, &update); err != nil {
+			log.Printf("[stream] unable to unmarshal update: %q", err.Error())
+			continue
+		}
+		h.broadcast(&update)
+	}
+}
+
+func (h *streamHub) broadcast(update *updateMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, r := range update.Reports {
+		data, err := json.Marshal(r)
+		if err != nil {
+			log.Printf("[stream] unable to marshal report: %q", err.Error())
+			continue
+		}
+
+		for c := range h.clients {
+			if !c.wants(r) {
+				continue
+			}
+
+			select {
+			case c.events <- data:
+			default:
+				log.Printf("[stream] dropping event for slow client")
+			}
+		}
+	}
+}
+
+func (h *streamHub) register(region, subregion string) *streamClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := &streamClient{
+		region:    normalizeString(region),
+		subregion: normalizeString(subregion),
+		events:    make(chan []byte, streamClientBuffer),
+	}
+	h.clients[c] = struct{}{}
+
+	return c
+}
+
+func (h *streamHub) unregister(c *streamClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients, c)
+}
+
+func (c *streamClient) wants(r *PollenReport) bool {
+	if c.region != "" && !strings.EqualFold(c.region, normalizeString(r.Region)) {
+		return false
+	}
+	if c.subregion != "" && !strings.EqualFold(c.subregion, r.Key()) {
+		return false
+	}
+	return true
+}
+
+func (s *server) handleStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok || s.hub == nil {
+			respond(w, http.StatusServiceUnavailable, &invalidRequestResponse{"Streaming is not supported"})
+			return
+		}
+
+		q := r.URL.Query()
+		c := s.hub.register(q.Get("region"), q.Get("subregion"))
+		defer s.hub.unregister(c)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		// Keep nginx from buffering the stream.
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": connected\n\n")
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": ping\n\n")
+			case data := <-c.events:
+				fmt.Fprintf(w, "event: report\ndata: %s\n\n", data)
+			}
+			flusher.Flush()
+		}
+	}
+}
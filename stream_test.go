@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportUpdateChanged(t *testing.T) {
+	a := reportWithSeverities("subregion-aa", map[string]string{"birke": "1"})
+	b := reportWithSeverities("subregion-aa", map[string]string{"birke": "1"})
+	c := reportWithSeverities("subregion-aa", map[string]string{"birke": "2"})
+
+	if !(&ReportUpdate{nil, a}).Changed() {
+		t.Error("expected new report to be changed")
+	}
+	if (&ReportUpdate{a, b}).Changed() {
+		t.Error("expected identical report to be unchanged")
+	}
+	if !(&ReportUpdate{a, c}).Changed() {
+		t.Error("expected different report to be changed")
+	}
+}
+
+func TestUpdatePublisherOnlyPublishesChangedReports(t *testing.T) {
+	bus := newLocalBus()
+	ch, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	unchanged := reportWithSeverities("subregion-aa", map[string]string{"birke": "1"})
+	changed := reportWithSeverities("subregion-ab", map[string]string{"birke": "2"})
+
+	p := &updatePublisher{bus}
+	go p.ReportsSynced([]*ReportUpdate{
+		{unchanged, unchanged},
+		{reportWithSeverities("subregion-ab", map[string]string{"birke": "1"}), changed},
+	})
+
+	var msg updateMessage
+	json.Unmarshal(<-ch, &msg)
+
+	if len(msg.Reports) != 1 || msg.Reports[0].SubRegion != "subregion-ab" {
+		t.Errorf("expected only the changed report to be published, got %+v", msg.Reports)
+	}
+}
+
+func TestStream(t *testing.T) {
+	bus := newLocalBus()
+	s := createServer()
+	s.hub = newStreamHub()
+	go s.hub.listen(bus)
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/pollen/stream?subregion=Subregion_AB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("wanted event stream, got %q", ct)
+	}
+
+	body := bufio.NewReader(res.Body)
+	// Wait for the connected comment so we know the client
+	// has been registered.
+	body.ReadString('\n')
+
+	msg, _ := json.Marshal(&updateMessage{[]*PollenReport{
+		reportWithSeverities("subregion-aa", map[string]string{"birke": "1"}),
+		reportWithSeverities("subregion-ab", map[string]string{"birke": "2"}),
+	}})
+	bus.Publish(msg)
+
+	lines := make(chan string)
+	go func() {
+		for {
+			line, err := body.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	var events []string
+	timeout := time.After(time.Second)
+	for len(events) < 1 {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "data: ") {
+				events = append(events, line)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for event")
+		}
+	}
+
+	var got PollenReport
+	json.Unmarshal([]byte(strings.TrimPrefix(events[0], "data: ")), &got)
+	if got.SubRegion != "subregion-ab" {
+		t.Errorf("expected event for subregion-ab, got %+v", got)
+	}
+}
+
+func TestLocalBusDoesNotBlockOnSlowSubscribers(t *testing.T) {
+	bus := newLocalBus()
+	bus.Subscribe()
+	fast, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < localBusBuffer*2; i++ {
+			bus.Publish([]byte("update"))
+			<-fast
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected publishing not to block on a subscriber which doesn't read")
+	}
+}
+
+func TestOnlyStreamsAreExemptFromWriteTimeout(t *testing.T) {
+	s := createServer()
+
+	flushes := make(map[string]bool)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		_, flushes[r.URL.Path] = w.(http.Flusher)
+	}
+	s.router.HandleFunc("/regular", handler)
+	s.router.HandleFunc("/regular/stream", handler)
+	s.streamFunc("/stream", handler)
+
+	for _, path := range []string{"/regular", "/regular/stream", "/stream"} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	// Responses which are subject to the timeout get buffered
+	// and can't be flushed.
+	want := map[string]bool{"/regular": false, "/regular/stream": false, "/stream": true}
+	for path, flusher := range want {
+		if flushes[path] != flusher {
+			t.Errorf("%s: expected flusher %v, got %v", path, flusher, flushes[path])
+		}
+	}
+}
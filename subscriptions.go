@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type createSubscriptionRequest struct {
+	URL         string   `json:"url"`
+	Subregion   string   `json:"subregion"`
+	Species     []string `json:"species"`
+	MinSeverity string   `json:"min_severity"`
+}
+
+func (s *server) subscriptionRoutes() {
+	s.router.HandleFunc("/subscriptions", s.handleCreateSubscription()).Methods("POST")
+	s.router.HandleFunc("/subscriptions/{id}", s.handleGetSubscription()).Methods("GET")
+	s.router.HandleFunc("/subscriptions/{id}", s.handleDeleteSubscription()).Methods("DELETE")
+	s.router.HandleFunc("/subscriptions/{id}/deliveries", s.handleGetDeliveries()).Methods("GET")
+}
+
+func (s *server) handleCreateSubscription() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.subscriptions == nil {
+			respond(w, http.StatusServiceUnavailable, &invalidRequestResponse{"Subscriptions are not supported"})
+			return
+		}
+
+		var req createSubscriptionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{"Invalid JSON body"})
+			return
+		}
+
+		sub, msg := s.newSubscription(&req)
+		if msg != "" {
+			respond(w, http.StatusBadRequest, &invalidRequestResponse{msg})
+			return
+		}
+
+		if err := s.subscriptions.SaveSubscription(sub); err != nil {
+			log.Printf("[routes] unable to save subscription: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		// This is the only time the secret gets handed out.
+		respond(w, http.StatusCreated, sub)
+	}
+}
+
+// newSubscription validates the request and turns it into a
+// subscription. If the request is invalid, it returns a message
+// explaining why.
+func (s *server) newSubscription(req *createSubscriptionRequest) (*Subscription, string) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "url has to be an absolute http or https URL"
+	}
+	if err := checkWebhookHost(u.Hostname()); err != nil {
+		return nil, "url has to point to a public address"
+	}
+
+	if _, ok := severityMap[req.MinSeverity]; !ok {
+		return nil, "min_severity has to be one of 0, 0-1, 1, 1-2, 2, 2-3, 3"
+	}
+
+	report, suggestions, err := s.lookupSubregion(req.Subregion)
+	if err != nil {
+		if err == ErrNotFound {
+			if len(suggestions) > 0 {
+				return nil, "Unknown subregion, did you mean " + strings.Join(suggestions, ", ") + "?"
+			}
+			return nil, "Unknown subregion"
+		}
+		return nil, "Unable to verify subregion"
+	}
+	subregion := report.Key()
+
+	species := make([]string, 0, len(req.Species))
+	for _, slug := range req.Species {
+		sp, ok := lookupSpeciesBySlug(slug)
+		if !ok {
+			return nil, "Unknown species " + slug
+		}
+		species = append(species, sp.Slug)
+	}
+
+	return &Subscription{
+		ID:          randomToken(16),
+		URL:         u.String(),
+		Subregion:   subregion,
+		Species:     species,
+		MinSeverity: req.MinSeverity,
+		Secret:      randomToken(32),
+		CreatedAt:   time.Now(),
+	}, ""
+}
+
+func (s *server) handleGetSubscription() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, ok := s.loadSubscription(w, r)
+		if !ok {
+			return
+		}
+
+		sub.Secret = ""
+		respond(w, http.StatusOK, sub)
+	}
+}
+
+func (s *server) handleDeleteSubscription() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, ok := s.loadSubscription(w, r)
+		if !ok {
+			return
+		}
+
+		if err := s.subscriptions.DeleteSubscription(sub.ID); err != nil {
+			log.Printf("[routes] unable to delete subscription: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		respond(w, http.StatusNoContent, nil)
+	}
+}
+
+func (s *server) handleGetDeliveries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, ok := s.loadSubscription(w, r)
+		if !ok {
+			return
+		}
+
+		ds, err := s.subscriptions.Deliveries(sub.ID)
+		if err != nil {
+			log.Printf("[routes] unable to load deliveries: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		respond(w, http.StatusOK, ds)
+	}
+}
+
+// loadSubscription fetches the subscription referenced in the
+// URL. If that fails, it writes the response and returns false.
+func (s *server) loadSubscription(w http.ResponseWriter, r *http.Request) (*Subscription, bool) {
+	if s.subscriptions == nil {
+		respond(w, http.StatusServiceUnavailable, &invalidRequestResponse{"Subscriptions are not supported"})
+		return nil, false
+	}
+
+	sub, err := s.subscriptions.GetSubscription(mux.Vars(r)["id"])
+	if err != nil {
+		if err == ErrNotFound {
+			respond(w, http.StatusNotFound, &invalidRequestResponse{"No subscription found"})
+			return nil, false
+		}
+
+		log.Printf("[routes] unable to load subscription: %q", err.Error())
+		respond(w, http.StatusInternalServerError, nil)
+		return nil, false
+	}
+
+	return sub, true
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// summary aggregates the reports of several subregions.
+type summary struct {
+	Region     string                 `json:"region,omitempty"`
+	Subregions int                    `json:"subregions"`
+	LastUpdate time.Time              `json:"last_update"`
+	Days       map[string]*daySummary `json:"days"`
+}
+
+type daySummary struct {
+	Date           string                     `json:"date"`
+	Species        map[string]*speciesSummary `json:"species"`
+	WorstSubregion *worstSubregion            `json:"worst_subregion"`
+}
+
+type speciesSummary struct {
+	Name string `json:"name"`
+	// Max is the highest severity in any subregion.
+	Max string `json:"max"`
+	// Mean is the average severity level across all subregions,
+	// where ranges like "1-2" count as 1.5.
+	Mean float64 `json:"mean"`
+
+	sum   float64
+	count int
+	max   float64
+}
+
+// worstSubregion is the subregion with the highest combined
+// severity level of all species on a day.
+type worstSubregion struct {
+	Region    string  `json:"region"`
+	SubRegion string  `json:"sub_region"`
+	Score     float64 `json:"score"`
+}
+
+// summarize computes the max and mean severity of every species
+// and the worst subregion for each day.
+func summarize(rs []*PollenReport) *summary {
+	sorted := make([]*PollenReport, len(rs))
+	copy(sorted, rs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key() < sorted[j].Key()
+	})
+
+	s := &summary{
+		Subregions: len(sorted),
+		Days:       make(map[string]*daySummary),
+	}
+
+	for _, r := range sorted {
+		if r.LastUpdate.After(s.LastUpdate) {
+			s.LastUpdate = r.LastUpdate
+		}
+	}
+
+	for _, r := range sorted {
+		scores := make(map[string]float64)
+
+		for _, p := range r.Pollen {
+			for _, d := range p.days() {
+				if d.report == nil {
+					continue
+				}
+
+				level, ok := severityLevel(d.report.Severity)
+				if !ok {
+					continue
+				}
+
+				day, ok := s.Days[d.name]
+				if !ok {
+					day = &daySummary{
+						Date:    s.date(d.name),
+						Species: make(map[string]*speciesSummary),
+					}
+					s.Days[d.name] = day
+				}
+
+				sp, ok := day.Species[p.Slug]
+				if !ok {
+					sp = &speciesSummary{Name: p.Name, Max: d.report.Severity, max: level}
+					day.Species[p.Slug] = sp
+				}
+
+				sp.sum += level
+				sp.count++
+				sp.Mean = math.Round(sp.sum/float64(sp.count)*100) / 100
+				if level > sp.max {
+					sp.max = level
+					sp.Max = d.report.Severity
+				}
+
+				scores[d.name] += level
+			}
+		}
+
+		for name, score := range scores {
+			day := s.Days[name]
+			if day.WorstSubregion == nil || score > day.WorstSubregion.Score {
+				day.WorstSubregion = &worstSubregion{r.Region, r.SubRegion, score}
+			}
+		}
+	}
+
+	return s
+}
+
+func (s *summary) date(day string) string {
+	if s.LastUpdate.IsZero() {
+		return ""
+	}
+
+	r := &PollenReport{LastUpdate: s.LastUpdate}
+	return r.Date().AddDate(0, 0, dayOffset(day)).Format("2006-01-02")
+}
+
+func (s *server) handleGetSummary() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rs, err := s.storage.AllReports()
+		if err != nil {
+			log.Printf("[routes] unable to load reports: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		rs = filterSource(rs, r.URL.Query().Get("source"))
+		respond(w, http.StatusOK, summarize(rs))
+	}
+}
+
+func (s *server) handleGetRegionSummary() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rs, suggestions, err := s.lookupRegion(mux.Vars(r)["region"])
+		if err != nil {
+			if err == ErrNotFound {
+				respondNotFound(w, suggestions)
+				return
+			}
+
+			log.Printf("[routes] unable to load reports: %q", err.Error())
+			respond(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		region := rs[0].Region
+		sum := summarize(filterSource(rs, r.URL.Query().Get("source")))
+		sum.Region = region
+		respond(w, http.StatusOK, sum)
+	}
+}
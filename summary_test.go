@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSummarize(t *testing.T) {
+	lastUpdate := time.Date(2020, 3, 31, 11, 0, 0, 0, dwdLocation)
+
+	a := reportWithSeverities("subregion-aa", map[string]string{"birke": "1", "hasel": "0"})
+	b := reportWithSeverities("subregion-ab", map[string]string{"birke": "2-3", "hasel": "0-1"})
+	c := reportWithSeverities("subregion-ac", map[string]string{"birke": "2", "hasel": "1"})
+	for _, r := range []*PollenReport{a, b, c} {
+		r.LastUpdate = lastUpdate
+	}
+
+	got := summarize([]*PollenReport{c, a, b})
+
+	today := got.Days[dayToday]
+	if today == nil || today.Date != "2020-03-31" {
+		t.Fatalf("unexpected summary for today %+v", today)
+	}
+
+	if got.Subregions != 3 || !got.LastUpdate.Equal(lastUpdate) {
+		t.Errorf("unexpected summary %+v", got)
+	}
+
+	wantSpecies := map[string]struct {
+		max  string
+		mean float64
+	}{
+		"birke": {"2-3", 1.83},
+		"hasel": {"1", 0.5},
+	}
+	if len(today.Species) != len(wantSpecies) {
+		t.Errorf("expected %d species, got %d", len(wantSpecies), len(today.Species))
+	}
+	for slug, want := range wantSpecies {
+		got := today.Species[slug]
+		if got == nil || got.Max != want.max || got.Mean != want.mean {
+			t.Errorf("%s: want max %q and mean %v, got %+v", slug, want.max, want.mean, got)
+		}
+	}
+
+	// b and c have the same score, the first one in
+	// alphabetical order wins.
+	want := &worstSubregion{"region-a", "subregion-ab", 3}
+	if !cmp.Equal(today.WorstSubregion, want) {
+		t.Errorf("want worst subregion %+v, got %+v", want, today.WorstSubregion)
+	}
+
+	if got.Days[dayTomorrow].Date != "2020-04-01" {
+		t.Errorf("unexpected date for tomorrow %q", got.Days[dayTomorrow].Date)
+	}
+}
+
+func TestSummaryEndpoints(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	s := createServer()
+	s.storage = newStorage(mr)
+
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"/pollen/summary", http.StatusOK},
+		{"/pollen/region/region-a/summary", http.StatusOK},
+		{"/pollen/region/region-x/summary", http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+
+			if w.Code != tc.want {
+				t.Errorf("wanted status %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+}
@@ -1,15 +1,27 @@
 package main
 
 import (
-	"encoding/json"
+	"io"
+	"io/ioutil"
 	"log"
-	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 const (
 	dataURL = "https://opendata.dwd.de/climate_environment/health/alerts/s31fg.json"
+
+	// maxSyncRuns is the number of sync runs the Syncer
+	// keeps around for inspection.
+	maxSyncRuns = 20
+
+	triggerSchedule = "schedule"
+	triggerAdmin    = "admin"
+	triggerImport   = "import"
+	triggerManual   = "manual"
 )
 
 // Syncer represents a type responsible for updating
@@ -17,16 +29,86 @@ const (
 type Syncer struct {
 	storage  Storage
 	interval time.Duration
-	url      string
+	upstream upstream
+
+	// source maps the data provided by the upstream to reports.
+	// If it is nil, the upstream provides data from the DWD.
+	source Source
+
+	// minLocations is the minimum number of locations a valid
+	// response from the DWD has to contain, if no source is set.
+	// Zero disables the check.
+	minLocations int
+
+	// quarantineDir is where payloads which failed validation
+	// get written to. If empty, they are discarded.
+	quarantineDir string
+
+	// observers get notified about every report saved
+	// during a sync. They have their own lock, so registering
+	// one doesn't wait for a running sync.
+	observersMu sync.RWMutex
+	observers   []SyncObserver
+
+	// mu makes sure only one sync runs at a time, no matter
+	// if it was started by the daemon or triggered manually.
+	mu sync.Mutex
+
+	runsMu sync.RWMutex
+	runs   []*SyncRun
+}
+
+// SyncObserver gets notified after a sync saved new reports.
+type SyncObserver interface {
+	ReportsSynced(updates []*ReportUpdate)
+}
+
+// ReportUpdate contains a freshly synced report together with
+// the report it replaced. Previous is nil if there was none.
+type ReportUpdate struct {
+	Previous *PollenReport
+	Current  *PollenReport
+}
+
+// SyncRun describes the outcome of a single sync run.
+type SyncRun struct {
+	Source     string    `json:"source"`
+	Trigger    string    `json:"trigger"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMS int64     `json:"duration_ms"`
+	Reports    int       `json:"reports"`
+	Saved      int       `json:"saved"`
+	Unchanged  int       `json:"unchanged"`
+	Outdated   int       `json:"outdated"`
+	Failed     int       `json:"failed"`
+	LastUpdate string    `json:"last_update"`
+	Error      string    `json:"error,omitempty"`
+	Problems   []string  `json:"problems,omitempty"`
+	Quarantine string    `json:"quarantine,omitempty"`
 }
 
 // NewSyncer returns a new syncer configured to fetch
 // data from the opendata server.
 func NewSyncer(s Storage, interval time.Duration) *Syncer {
+	return NewUpstreamSyncer(s, &httpUpstream{dataURL}, interval)
+}
+
+// NewEnvSyncer returns a syncer for the DWD configured via
+// environment variables. If the variables are not set, it fetches
+// data from the opendata server once per hour.
+func NewEnvSyncer(s Storage) (*Syncer, error) {
+	return newEnvSourceSyncer(s, sourceDWD)
+}
+
+// NewUpstreamSyncer returns a new syncer which reads its
+// data from the provided upstream.
+func NewUpstreamSyncer(s Storage, u upstream, interval time.Duration) *Syncer {
 	return &Syncer{
-		storage:  s,
-		interval: interval,
-		url:      dataURL,
+		storage:      s,
+		interval:     interval,
+		upstream:     u,
+		minLocations: expectedLocations,
 	}
 }
 
@@ -40,6 +122,25 @@ var severityMap = map[string]string{
 	"3":   "hohe Belastung",
 }
 
+// severityLevels maps the severities used by the DWD to numbers
+// so they can be compared. Ranges like "1-2" sit in between.
+var severityLevels = map[string]float64{
+	"0":   0,
+	"0-1": 0.5,
+	"1":   1,
+	"1-2": 1.5,
+	"2":   2,
+	"2-3": 2.5,
+	"3":   3,
+}
+
+// severityLevel returns the numeric level of a severity and
+// whether it is a known severity at all.
+func severityLevel(severity string) (float64, bool) {
+	l, ok := severityLevels[severity]
+	return l, ok
+}
+
 type openDataPollenResponse struct {
 	NextUpdate string                    `json:"next_update"`
 	Name       string                    `json:"name"`
@@ -67,23 +168,17 @@ type openDataLegend struct {
 }
 
 type openDataLocationReport struct {
-	RegionID       int                   `json:"region_id"`
-	RegionName     string                `json:"region_name"`
-	PartRegionID   int                   `json:"partregion_id"`
-	PartregionName string                `json:"partregion_name"`
-	Pollen         *openDataPollenReport `json:"Pollen"`
+	RegionID       int                  `json:"region_id"`
+	RegionName     string               `json:"region_name"`
+	PartRegionID   int                  `json:"partregion_id"`
+	PartregionName string               `json:"partregion_name"`
+	Pollen         openDataPollenReport `json:"Pollen"`
 }
 
-type openDataPollenReport struct {
-	Ambrosia *openDataSinglePollenReport `json:"Ambrosia"`
-	Beifuss  *openDataSinglePollenReport `json:"Beifuss"`
-	Birke    *openDataSinglePollenReport `json:"Birke"`
-	Erle     *openDataSinglePollenReport `json:"Erle"`
-	Esche    *openDataSinglePollenReport `json:"Esche"`
-	Graeser  *openDataSinglePollenReport `json:"Graeser"`
-	Hasel    *openDataSinglePollenReport `json:"Hasel"`
-	Roggen   *openDataSinglePollenReport `json:"Roggen"`
-}
+// openDataPollenReport maps the DWD's name of a species to its
+// forecast. It is a map so new species get picked up without
+// having to touch the code.
+type openDataPollenReport map[string]*openDataSinglePollenReport
 
 type openDataSinglePollenReport struct {
 	Tomorrow         string `json:"tomorrow"`
@@ -93,59 +188,348 @@ type openDataSinglePollenReport struct {
 
 // Run starts the syncer daemon. It will fetch new data
 // in the configured interval and save it to the storage.
+// When replaying snapshots, it stops after the last one.
 func (s *Syncer) Run() {
 	log.Printf("[sync] starting sync daemon…")
-	done := make(chan struct{})
-
-	go s.sync(done)
 
 	for {
-		<-done
-		log.Printf("[sync] finished syncing…")
+		s.Sync(triggerSchedule)
+
+		if s.replayFinished() {
+			log.Printf("[sync] replayed all snapshots, stopping sync daemon")
+			return
+		}
+
 		time.Sleep(s.interval)
-		go s.sync(done)
 	}
 }
 
-func (s *Syncer) sync(done chan struct{}) {
+// replayFinished checks if the syncer replays snapshots and has
+// already synced all of them.
+func (s *Syncer) replayFinished() bool {
+	r, ok := s.upstream.(*replayUpstream)
+	return ok && r.remaining() == 0
+}
+
+// Sync fetches fresh data from the opendata server and writes
+// it to the storage. The outcome gets recorded and can later
+// be inspected via Runs.
+func (s *Syncer) Sync(trigger string) *SyncRun {
+	return s.do(trigger, s.sync)
+}
+
+// Import reads a file in the opendata format and writes its
+// reports to the storage, just like a regular sync would.
+func (s *Syncer) Import(r io.Reader) *SyncRun {
+	return s.do(triggerImport, func(run *SyncRun) error {
+		return s.process(run, r)
+	})
+}
+
+func (s *Syncer) do(trigger string, fn func(run *SyncRun) error) *SyncRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	log.Printf("[sync] Starting sync run…")
 
-	resp, err := http.Get(s.url)
+	run := &SyncRun{
+		Source:    s.dataSource().Name(),
+		Trigger:   trigger,
+		StartedAt: time.Now(),
+	}
+
+	if err := s.safely(run, fn); err != nil {
+		log.Printf("[sync] sync run failed: %q", err.Error())
+		run.Error = err.Error()
+		for _, p := range run.Problems {
+			log.Printf("[sync] validation problem: %s", p)
+		}
+	} else {
+		log.Printf("[sync] finished syncing…")
+	}
+
+	run.FinishedAt = time.Now()
+	run.DurationMS = int64(run.FinishedAt.Sub(run.StartedAt) / time.Millisecond)
+	s.record(run)
+
+	return run
+}
+
+// safely calls fn, turning a panic into an error. Bad upstream
+// data should never be able to take down the whole server.
+func (s *Syncer) safely(run *SyncRun, fn func(run *SyncRun) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("sync panicked: %v", r)
+		}
+	}()
+
+	return fn(run)
+}
+
+// Observe registers an observer which gets notified after
+// every successful sync.
+func (s *Syncer) Observe(o SyncObserver) {
+	s.observersMu.Lock()
+	defer s.observersMu.Unlock()
+
+	s.observers = append(s.observers, o)
+}
+
+// Runs returns the most recent sync runs, newest first.
+func (s *Syncer) Runs() []*SyncRun {
+	s.runsMu.RLock()
+	defer s.runsMu.RUnlock()
+
+	runs := make([]*SyncRun, len(s.runs))
+	for i, r := range s.runs {
+		runs[len(s.runs)-1-i] = r
+	}
+
+	return runs
+}
+
+func (s *Syncer) record(run *SyncRun) {
+	s.runsMu.Lock()
+	defer s.runsMu.Unlock()
+
+	s.runs = append(s.runs, run)
+	if len(s.runs) > maxSyncRuns {
+		s.runs = s.runs[len(s.runs)-maxSyncRuns:]
+	}
+}
+
+func (s *Syncer) sync(run *SyncRun) error {
+	body, err := s.upstream.Fetch()
 	if err != nil {
-		log.Printf("[sync] unable to fetch data: %q", err.Error())
-		return
+		return err
 	}
 
-	defer resp.Body.Close()
+	defer body.Close()
 
-	var data openDataPollenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		log.Printf("[sync] unable decode response: %q", err.Error())
-		return
+	return s.process(run, body)
+}
+
+// process decodes the data of the syncer's source and saves the
+// contained reports.
+//
+// Data which can't be decoded or fails validation is rejected,
+// so the previously saved reports stay untouched.
+func (s *Syncer) process(run *SyncRun, r io.Reader) error {
+	payload, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "unable to read response")
+	}
+
+	source := s.dataSource()
+	data, err := source.Map(payload)
+	if data != nil {
+		run.LastUpdate = data.LastUpdate
 	}
+	if err != nil {
+		if verr, ok := err.(*ValidationError); ok {
+			run.Problems = verr.Problems
+		}
+		s.quarantine(run, payload)
+		return err
+	}
+
+	run.Reports = len(data.Reports)
+
+	var updates []*ReportUpdate
+	for _, r := range data.Reports {
+		r.Source = source.Name()
+
+		previous, err := s.storage.GetBySubregion(r.Key())
+		if err != nil && err != ErrNotFound {
+			log.Printf("[sync] unable to load previous report for %q: %q", r.Key(), err.Error())
+		}
+
+		// Importing an old file must not overwrite a newer
+		// forecast with stale data.
+		if previous != nil && r.LastUpdate.Before(previous.LastUpdate) {
+			log.Printf("[sync] skipping report for %q older than the stored one", r.Key())
+			run.Outdated++
+			continue
+		}
+
+		update := &ReportUpdate{previous, r}
+
+		// There's no point in rewriting reports which are the
+		// same as the last time we synced. A new forecast with the
+		// same severities still gets saved, otherwise the dates
+		// derived from the last update would go stale.
+		if !update.Updated() {
+			run.Unchanged++
+			continue
+		}
+
+		if err := s.storage.Save(r); err != nil {
+			log.Printf("[sync] unable to save report for %q: %q", r.SubRegion, err.Error())
+			run.Failed++
+			continue
+		}
+		run.Saved++
+
+		// Observers only get to see reports which can be served.
+		updates = append(updates, update)
+	}
+
+	s.observersMu.RLock()
+	observers := s.observers
+	s.observersMu.RUnlock()
 
-	mapped := mapResponse(&data)
+	for _, o := range observers {
+		o.ReportsSynced(updates)
+	}
+
+	return nil
+}
 
-	for _, r := range mapped {
-		s.storage.Save(r)
+// dataSource returns the source the syncer reads from.
+func (s *Syncer) dataSource() Source {
+	if s.source != nil {
+		return s.source
 	}
+	return &dwdSource{name: sourceDWD, minLocations: s.minLocations}
+}
 
-	done <- struct{}{}
+func (s *Syncer) quarantine(run *SyncRun, payload []byte) {
+	if s.quarantineDir == "" {
+		return
+	}
+
+	path, err := quarantine(s.quarantineDir, payload)
+	if err != nil {
+		log.Printf("[sync] unable to quarantine payload: %q", err.Error())
+		return
+	}
+
+	log.Printf("[sync] quarantined rejected payload at %s", path)
+	run.Quarantine = path
 }
 
 // PollenReport is the internal representation of the open data
 // polen report with a slightly more sane structure.
 type PollenReport struct {
-	Region    string    `json:"region"`
-	SubRegion string    `json:"sub_region"`
-	Pollen    []*pollen `json:"pollen"`
+	Source     string    `json:"source"`
+	Region     string    `json:"region"`
+	SubRegion  string    `json:"sub_region"`
+	LastUpdate time.Time `json:"last_update"`
+	Pollen     []*pollen `json:"pollen"`
+}
+
+// Key returns the identifier of the report's subregion. Not
+// all regions have subregions, in which case the region's
+// name is used instead. The key includes the source, so
+// providers with locations of the same name don't overwrite
+// each other.
+func (r *PollenReport) Key() string {
+	location := r.SubRegion
+	if normalizeString(location) == "" {
+		location = r.Region
+	}
+	return sourceKey(r.Source, location)
+}
+
+// Date returns the day the report's "today" refers to, in
+// German local time. Adding days gets you to the other days.
+// Reports saved before the time of the last update was kept
+// don't have one, they are assumed to be current.
+func (r *PollenReport) Date() time.Time {
+	t := r.LastUpdate
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	t = t.In(dwdLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, dwdLocation)
+}
+
+// dayOffset returns the number of days a day's name is
+// away from today.
+func dayOffset(name string) int {
+	switch name {
+	case dayTomorrow:
+		return 1
+	case dayDayAfterTomorrow:
+		return 2
+	}
+	return 0
 }
 
 type pollen struct {
 	Name             string           `json:"name"`
-	Today            *pollenDayReport `json:"today"`
-	Tomorrow         *pollenDayReport `json:"tomorrow"`
-	DayAfterTomorrow *pollenDayReport `json:"day_after_tomorrow"`
+	Slug             string           `json:"slug"`
+	LatinName        string           `json:"latin_name"`
+	Today            *pollenDayReport `json:"today,omitempty"`
+	Tomorrow         *pollenDayReport `json:"tomorrow,omitempty"`
+	DayAfterTomorrow *pollenDayReport `json:"day_after_tomorrow,omitempty"`
+}
+
+const (
+	dayToday            = "today"
+	dayTomorrow         = "tomorrow"
+	dayDayAfterTomorrow = "day_after_tomorrow"
+)
+
+type namedDayReport struct {
+	name   string
+	report *pollenDayReport
+}
+
+// species returns the forecast for the species with the
+// provided slug, or nil if the report doesn't contain it.
+func (r *PollenReport) species(slug string) *pollen {
+	if r == nil {
+		return nil
+	}
+
+	for _, p := range r.Pollen {
+		if p.Slug == slug {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// days returns the reports of all days in chronological order.
+func (p *pollen) days() []namedDayReport {
+	return []namedDayReport{
+		{dayToday, p.Today},
+		{dayTomorrow, p.Tomorrow},
+		{dayDayAfterTomorrow, p.DayAfterTomorrow},
+	}
+}
+
+// day returns the report for the day with the provided name.
+func (p *pollen) day(name string) *pollenDayReport {
+	for _, d := range p.days() {
+		if d.name == name {
+			return d.report
+		}
+	}
+
+	return nil
+}
+
+// forecastOn returns the forecast of the species for the provided
+// calendar date, or nil if the report doesn't cover that date.
+// Reports issued on different days name the same date differently.
+func (r *PollenReport) forecastOn(slug string, date time.Time) *pollenDayReport {
+	p := r.species(slug)
+	if p == nil {
+		return nil
+	}
+
+	for _, d := range p.days() {
+		if r.Date().AddDate(0, 0, dayOffset(d.name)).Equal(date) {
+			return d.report
+		}
+	}
+
+	return nil
 }
 
 type pollenDayReport struct {
@@ -156,11 +540,14 @@ type pollenDayReport struct {
 func mapResponse(r *openDataPollenResponse) []*PollenReport {
 	var result []*PollenReport
 
+	lastUpdate, _ := parseDWDTime(r.LastUpdate)
+
 	for _, lr := range r.Content {
 		r := &PollenReport{
-			strings.TrimSpace(lr.RegionName),
-			strings.TrimSpace(lr.PartregionName),
-			mapLocationReport(lr.Pollen),
+			Region:     strings.TrimSpace(lr.RegionName),
+			SubRegion:  strings.TrimSpace(lr.PartregionName),
+			LastUpdate: lastUpdate,
+			Pollen:     mapLocationReport(lr.Pollen),
 		}
 
 		result = append(result, r)
@@ -169,28 +556,28 @@ func mapResponse(r *openDataPollenResponse) []*PollenReport {
 	return result
 }
 
-func mapLocationReport(r *openDataPollenReport) []*pollen {
+func mapLocationReport(r openDataPollenReport) []*pollen {
 	var result []*pollen
 
-	result = append(result, mapPollenReport("Ambrosia", r.Ambrosia))
-	result = append(result, mapPollenReport("Beifuss", r.Beifuss))
-	result = append(result, mapPollenReport("Birke", r.Birke))
-	result = append(result, mapPollenReport("Erle", r.Erle))
-	result = append(result, mapPollenReport("Esche", r.Esche))
-	result = append(result, mapPollenReport("Gräser", r.Graeser))
-	result = append(result, mapPollenReport("Hasel", r.Hasel))
-	result = append(result, mapPollenReport("Roggen", r.Roggen))
+	for _, key := range sortedSpeciesKeys(r) {
+		if r[key] == nil {
+			continue
+		}
+		result = append(result, mapPollenReport(lookupSpecies(key), r[key]))
+	}
 
 	return result
 }
 
-func mapPollenReport(name string, r *openDataSinglePollenReport) *pollen {
+func mapPollenReport(s *Species, r *openDataSinglePollenReport) *pollen {
 	todayDesc, _ := severityMap[r.Today]
 	tomorrowDesc, _ := severityMap[r.Tomorrow]
 	dayAfterTomorrowDesc, _ := severityMap[r.DayAfterTomorrow]
 
 	p := &pollen{
-		name,
+		s.Name,
+		s.Slug,
+		s.LatinName,
 		&pollenDayReport{r.Today, todayDesc},
 		&pollenDayReport{r.Tomorrow, tomorrowDesc},
 		&pollenDayReport{r.DayAfterTomorrow, dayAfterTomorrowDesc},
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -26,6 +29,10 @@ func (s *inMemoryStorage) GetBySubregion(subregion string) (*PollenReport, error
 	return nil, nil
 }
 
+func (s *inMemoryStorage) GetBySubregions(subregions []string) ([]*PollenReport, error) {
+	return make([]*PollenReport, len(subregions)), nil
+}
+
 func (s *inMemoryStorage) AllReports() ([]*PollenReport, error) {
 	return s.data, nil
 }
@@ -38,52 +45,71 @@ func (s *inMemoryStorage) AllSubregions() ([]string, error) {
 	return nil, nil
 }
 
+// failingStorage can't save any report.
+type failingStorage struct {
+	inMemoryStorage
+}
+
+func (s *failingStorage) Save(r *PollenReport) error {
+	return errors.New("::unable to save::")
+}
+
+// recordingObserver remembers all updates it was notified about.
+type recordingObserver struct {
+	updates []*ReportUpdate
+}
+
+func (o *recordingObserver) ReportsSynced(updates []*ReportUpdate) {
+	o.updates = append(o.updates, updates...)
+}
+
 var upstreamResponse = &openDataPollenResponse{
 	Name:       "::name::",
-	NextUpdate: "2020-01-01 11:00 Uhr",
+	NextUpdate: "2020-01-02 11:00 Uhr",
+	LastUpdate: "2020-01-01 11:00 Uhr",
 	Content: []*openDataLocationReport{
 		{
 			RegionID:       123,
 			RegionName:     "::region-a::",
 			PartRegionID:   234,
 			PartregionName: "::region-a-subregion-a::",
-			Pollen: &openDataPollenReport{
-				Ambrosia: &openDataSinglePollenReport{
+			Pollen: openDataPollenReport{
+				"Ambrosia": &openDataSinglePollenReport{
 					Tomorrow:         "0",
 					Today:            "0-1",
 					DayAfterTomorrow: "1-2",
 				},
-				Beifuss: &openDataSinglePollenReport{
+				"Beifuss": &openDataSinglePollenReport{
 					Tomorrow:         "1",
 					Today:            "1-2",
 					DayAfterTomorrow: "1-2",
 				},
-				Birke: &openDataSinglePollenReport{
+				"Birke": &openDataSinglePollenReport{
 					Tomorrow:         "2",
 					Today:            "1",
 					DayAfterTomorrow: "2-3",
 				},
-				Erle: &openDataSinglePollenReport{
+				"Erle": &openDataSinglePollenReport{
 					Tomorrow:         "1",
 					Today:            "0",
 					DayAfterTomorrow: "1-2",
 				},
-				Esche: &openDataSinglePollenReport{
+				"Esche": &openDataSinglePollenReport{
 					Tomorrow:         "2",
 					Today:            "0",
 					DayAfterTomorrow: "2-3",
 				},
-				Graeser: &openDataSinglePollenReport{
+				"Graeser": &openDataSinglePollenReport{
 					Tomorrow:         "2",
 					Today:            "0",
 					DayAfterTomorrow: "2-3",
 				},
-				Hasel: &openDataSinglePollenReport{
+				"Hasel": &openDataSinglePollenReport{
 					Tomorrow:         "2",
 					Today:            "1-2",
 					DayAfterTomorrow: "1",
 				},
-				Roggen: &openDataSinglePollenReport{
+				"Roggen": &openDataSinglePollenReport{
 					Tomorrow:         "2",
 					Today:            "0",
 					DayAfterTomorrow: "2-3",
@@ -101,20 +127,28 @@ func TestSync(t *testing.T) {
 	}))
 	defer server.Close()
 
-	done := make(chan struct{})
 	syncer := &Syncer{
-		url:     server.URL,
-		storage: &inMemoryStorage{},
+		upstream: &httpUpstream{server.URL},
+		storage:  &inMemoryStorage{},
 	}
-	go syncer.sync(done)
+	run := syncer.Sync(triggerSchedule)
+	if run.Error != "" {
+		t.Fatalf("sync failed: %q", run.Error)
+	}
+
+	lastUpdate, _ := parseDWDTime(upstreamResponse.LastUpdate)
 
 	want := []*PollenReport{
 		{
-			Region:    "::region-a::",
-			SubRegion: "::region-a-subregion-a::",
+			Source:     sourceDWD,
+			Region:     "::region-a::",
+			SubRegion:  "::region-a-subregion-a::",
+			LastUpdate: lastUpdate,
 			Pollen: []*pollen{
 				{
-					Name: "Ambrosia",
+					Name:      "Ambrosia",
+					Slug:      "ambrosia",
+					LatinName: "Ambrosia artemisiifolia",
 					Today: &pollenDayReport{
 						Severity:    "0-1",
 						Description: "keine bis geringe Belastung",
@@ -129,7 +163,9 @@ func TestSync(t *testing.T) {
 					},
 				},
 				{
-					Name: "Beifuss",
+					Name:      "Beifuss",
+					Slug:      "beifuss",
+					LatinName: "Artemisia vulgaris",
 					Today: &pollenDayReport{
 						Severity:    "1-2",
 						Description: "geringe bis mittlere Belastung",
@@ -144,7 +180,9 @@ func TestSync(t *testing.T) {
 					},
 				},
 				{
-					Name: "Birke",
+					Name:      "Birke",
+					Slug:      "birke",
+					LatinName: "Betula",
 					Today: &pollenDayReport{
 						Severity:    "1",
 						Description: "geringe Belastung",
@@ -159,7 +197,9 @@ func TestSync(t *testing.T) {
 					},
 				},
 				{
-					Name: "Erle",
+					Name:      "Erle",
+					Slug:      "erle",
+					LatinName: "Alnus",
 					Today: &pollenDayReport{
 						Severity:    "0",
 						Description: "keine Belastung",
@@ -174,7 +214,9 @@ func TestSync(t *testing.T) {
 					},
 				},
 				{
-					Name: "Esche",
+					Name:      "Esche",
+					Slug:      "esche",
+					LatinName: "Fraxinus",
 					Today: &pollenDayReport{
 						Severity:    "0",
 						Description: "keine Belastung",
@@ -189,7 +231,9 @@ func TestSync(t *testing.T) {
 					},
 				},
 				{
-					Name: "Gräser",
+					Name:      "Gräser",
+					Slug:      "graeser",
+					LatinName: "Poaceae",
 					Today: &pollenDayReport{
 						Severity:    "0",
 						Description: "keine Belastung",
@@ -204,7 +248,9 @@ func TestSync(t *testing.T) {
 					},
 				},
 				{
-					Name: "Hasel",
+					Name:      "Hasel",
+					Slug:      "hasel",
+					LatinName: "Corylus",
 					Today: &pollenDayReport{
 						Severity:    "1-2",
 						Description: "geringe bis mittlere Belastung",
@@ -219,7 +265,9 @@ func TestSync(t *testing.T) {
 					},
 				},
 				{
-					Name: "Roggen",
+					Name:      "Roggen",
+					Slug:      "roggen",
+					LatinName: "Secale cereale",
 					Today: &pollenDayReport{
 						Severity:    "0",
 						Description: "keine Belastung",
@@ -237,11 +285,178 @@ func TestSync(t *testing.T) {
 		},
 	}
 
-	<-done
-
 	got, _ := syncer.storage.AllReports()
 	diff := cmp.Diff(got, want)
 	if diff != "" {
 		t.Error(diff)
 	}
 }
+
+func TestSyncRecordsRuns(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json, _ := json.Marshal(upstreamResponse)
+		w.Write(json)
+	}))
+	defer server.Close()
+
+	syncer := &Syncer{
+		upstream: &httpUpstream{server.URL},
+		storage:  &inMemoryStorage{},
+	}
+
+	syncer.Sync(triggerSchedule)
+	syncer.upstream = &httpUpstream{server.URL + "/missing"}
+	syncer.Sync(triggerAdmin)
+
+	runs := syncer.Runs()
+	if len(runs) != 2 {
+		t.Fatalf("expected 2 recorded runs, got %d", len(runs))
+	}
+
+	if runs[0].Trigger != triggerAdmin || runs[0].Error == "" {
+		t.Errorf("expected most recent run to be a failed admin run, got %+v", runs[0])
+	}
+
+	if runs[1].Error != "" || runs[1].Saved != 1 || runs[1].Reports != 1 {
+		t.Errorf("expected first run to have saved 1 report, got %+v", runs[1])
+	}
+}
+
+func TestSyncOnlyKeepsRecentRuns(t *testing.T) {
+	syncer := &Syncer{}
+	for i := 0; i < maxSyncRuns+5; i++ {
+		syncer.record(&SyncRun{Reports: i})
+	}
+
+	runs := syncer.Runs()
+	if len(runs) != maxSyncRuns {
+		t.Fatalf("expected %d runs, got %d", maxSyncRuns, len(runs))
+	}
+
+	if runs[0].Reports != maxSyncRuns+4 {
+		t.Errorf("expected newest run first, got %+v", runs[0])
+	}
+}
+
+func TestImport(t *testing.T) {
+	data, _ := json.Marshal(upstreamResponse)
+
+	storage := &inMemoryStorage{}
+	syncer := &Syncer{storage: storage}
+
+	run := syncer.Import(bytes.NewReader(data))
+	if run.Error != "" {
+		t.Fatalf("import failed: %q", run.Error)
+	}
+
+	if run.Trigger != triggerImport || run.Saved != 1 {
+		t.Errorf("expected a single imported report, got %+v", run)
+	}
+
+	if len(storage.data) != 1 || storage.data[0].SubRegion != "::region-a-subregion-a::" {
+		t.Errorf("expected imported report in storage, got %+v", storage.data)
+	}
+}
+
+func TestSyncOnlyNotifiesAboutSavedReports(t *testing.T) {
+	data, _ := json.Marshal(upstreamResponse)
+
+	observer := &recordingObserver{}
+	syncer := &Syncer{storage: &failingStorage{}}
+	syncer.Observe(observer)
+
+	run := syncer.Import(bytes.NewReader(data))
+	if run.Failed != 1 || run.Saved != 0 {
+		t.Errorf("expected the report to fail saving, got %+v", run)
+	}
+
+	if len(observer.updates) != 0 {
+		t.Errorf("expected no updates for reports which failed to save, got %+v", observer.updates)
+	}
+
+	syncer.storage = &inMemoryStorage{}
+	syncer.Import(bytes.NewReader(data))
+	if len(observer.updates) != 1 {
+		t.Errorf("expected an update for the saved report, got %+v", observer.updates)
+	}
+}
+
+func TestObserveDuringSync(t *testing.T) {
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	syncer := &Syncer{
+		upstream: &httpUpstream{server.URL},
+		storage:  &inMemoryStorage{},
+	}
+	go syncer.Sync(triggerSchedule)
+	<-requested
+
+	observed := make(chan struct{})
+	go func() {
+		syncer.Observe(&recordingObserver{})
+		close(observed)
+	}()
+
+	select {
+	case <-observed:
+	case <-time.After(time.Second):
+		t.Error("expected Observe to return while a sync is running")
+	}
+}
+
+func TestSyncSkipsUnchangedReports(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	syncer := &Syncer{storage: newStorage(mr)}
+	importAt := func(lastUpdate string) *SyncRun {
+		r := copyUpstreamResponse()
+		r.LastUpdate = lastUpdate
+		data, _ := json.Marshal(r)
+		return syncer.Import(bytes.NewReader(data))
+	}
+
+	tests := []struct {
+		description string
+		lastUpdate  string
+		saved       int
+		unchanged   int
+		outdated    int
+	}{
+		{"new report", "2020-01-01 11:00 Uhr", 1, 0, 0},
+		{"same report", "2020-01-01 11:00 Uhr", 0, 1, 0},
+		{"same severities in a new forecast", "2020-01-02 11:00 Uhr", 1, 0, 0},
+		{"older forecast", "2020-01-01 11:00 Uhr", 0, 0, 1},
+	}
+
+	for _, tc := range tests {
+		run := importAt(tc.lastUpdate)
+		if run.Saved != tc.saved || run.Unchanged != tc.unchanged || run.Outdated != tc.outdated {
+			t.Errorf("%s: expected %d saved, %d unchanged and %d outdated, got %+v", tc.description, tc.saved, tc.unchanged, tc.outdated, run)
+		}
+	}
+
+	data, _ := json.Marshal(copyUpstreamResponse())
+	imported, _ := (&dwdSource{}).Map(data)
+	newest, _ := parseDWDTime("2020-01-02 11:00 Uhr")
+	for _, r := range imported.Reports {
+		stored, err := syncer.storage.GetBySubregion(r.Key())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stored.LastUpdate.Equal(newest) {
+			t.Errorf("expected %s to keep the newer forecast, got %v", r.SubRegion, stored.LastUpdate)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// certCheckInterval is how often the certificate files are checked
+// for changes. Checking on every handshake would be wasteful.
+const certCheckInterval = 30 * time.Second
+
+// tlsConfig holds the TLS settings of the server.
+type tlsConfig struct {
+	certFile string
+	keyFile  string
+	// redirectAddr is the address of a plain HTTP listener which
+	// redirects to HTTPS. It is disabled if empty.
+	redirectAddr string
+}
+
+// newEnvTLSConfig returns the TLS settings configured via
+// environment variables. It returns nil if TLS is disabled.
+func newEnvTLSConfig() (*tlsConfig, error) {
+	c := &tlsConfig{
+		certFile:     os.Getenv("TLS_CERT_FILE"),
+		keyFile:      os.Getenv("TLS_KEY_FILE"),
+		redirectAddr: os.Getenv("TLS_REDIRECT_ADDR"),
+	}
+
+	if c.certFile == "" && c.keyFile == "" {
+		if c.redirectAddr != "" {
+			return nil, errors.New("TLS_REDIRECT_ADDR requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+
+	if c.certFile == "" || c.keyFile == "" {
+		return nil, errors.New("both TLS_CERT_FILE and TLS_KEY_FILE are required to enable TLS")
+	}
+
+	return c, nil
+}
+
+// certReloader loads the certificate from disk and reloads it when
+// the files change, so rotated certificates get picked up without
+// a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: certCheckInterval,
+	}
+
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. If the
+// changed certificate can't be loaded, the previous one is used.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < c.interval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+
+	modTime, err := c.lastModified()
+	if err != nil {
+		log.Printf("[tls] unable to check certificate: %q", err.Error())
+		return c.cert, nil
+	}
+
+	if modTime.After(c.modTime) {
+		if err := c.reload(); err != nil {
+			log.Printf("[tls] unable to reload certificate: %q", err.Error())
+		} else {
+			log.Printf("[tls] reloaded certificate %s", c.certFile)
+		}
+	}
+
+	return c.cert, nil
+}
+
+func (c *certReloader) reload() error {
+	modTime, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to load certificate")
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+	c.checked = time.Now()
+
+	return nil
+}
+
+// lastModified returns the time either the certificate or the
+// key was last modified.
+func (c *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// listenAndServeTLS serves HTTPS and HTTP/2 on the server's address.
+// If configured, it also redirects plain HTTP requests to HTTPS.
+func listenAndServeTLS(s *http.Server, c *tlsConfig) error {
+	certs, err := newCertReloader(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	s.TLSConfig = &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	errs := make(chan error, 2)
+
+	if c.redirectAddr != "" {
+		redirect := &http.Server{
+			Addr:         c.redirectAddr,
+			Handler:      redirectToHTTPS(s.Addr),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		go func() {
+			errs <- redirect.ListenAndServe()
+		}()
+	}
+
+	go func() {
+		errs <- s.ListenAndServeTLS("", "")
+	}()
+
+	return <-errs
+}
+
+// redirectToHTTPS redirects every request to the same URL on the
+// HTTPS server listening on addr.
+func redirectToHTTPS(addr string) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+
+		http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+	})
+}
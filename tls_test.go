@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for the common
+// name and its key to dir.
+func writeCertificate(t *testing.T, dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	commonName := func(c *certReloader) string {
+		cert, err := c.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	certFile, keyFile := writeCertificate(t, dir, "::first::")
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.interval = 0
+
+	if cn := commonName(c); cn != "::first::" {
+		t.Errorf("expected first certificate, got %q", cn)
+	}
+
+	writeCertificate(t, dir, "::second::")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	if cn := commonName(c); cn != "::second::" {
+		t.Errorf("expected rotated certificate, got %q", cn)
+	}
+
+	// A broken certificate must not replace the working one.
+	ioutil.WriteFile(certFile, []byte("::garbage::"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	if cn := commonName(c); cn != "::second::" {
+		t.Errorf("expected previous certificate to be kept, got %q", cn)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	testCases := []struct {
+		addr     string
+		url      string
+		expected string
+	}{
+		{":443", "http://achoo.dev/pollen?format=csv", "https://achoo.dev/pollen?format=csv"},
+		{":8443", "http://achoo.dev:8000/pollen", "https://achoo.dev:8443/pollen"},
+	}
+
+	for _, tc := range testCases {
+		r := httptest.NewRequest("GET", tc.url, nil)
+		w := httptest.NewRecorder()
+
+		redirectToHTTPS(tc.addr).ServeHTTP(w, r)
+
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tc.expected {
+			t.Errorf("%s: expected redirect to %q, got %d %q", tc.url, tc.expected, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
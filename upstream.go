@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const dwdTimeLayout = "2006-01-02 15:04 Uhr"
+
+var (
+	// errReplayFinished is returned by a replaying upstream once
+	// all snapshots have been fed to the syncer.
+	errReplayFinished = errors.New("upstream: replay finished")
+
+	dwdLocation = loadDWDLocation()
+
+	// upstreamClient fetches remote data. A hanging server must
+	// not block the syncer forever.
+	upstreamClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// upstream provides raw pollen data in the DWD opendata format.
+type upstream interface {
+	Fetch() (io.ReadCloser, error)
+}
+
+// newUpstream returns the upstream for the provided location.
+// URLs are fetched via HTTP, files are read from disk. For
+// directories of archived s31fg.json snapshots, the most recent
+// snapshot is used unless replay is set, in which case every
+// snapshot gets fed to the syncer in the order it was published.
+func newUpstream(location string, replay bool) (upstream, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return &httpUpstream{location}, nil
+	}
+
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, errors.Wrap(err, "upstream: invalid location")
+	}
+
+	if !info.IsDir() {
+		return &fileUpstream{location}, nil
+	}
+
+	if !replay {
+		return &directoryUpstream{location}, nil
+	}
+
+	files, err := snapshots(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return &replayUpstream{files: files}, nil
+}
+
+// httpUpstream fetches the data from a remote server, usually
+// the DWD opendata server.
+type httpUpstream struct {
+	url string
+}
+
+func (u *httpUpstream) Fetch() (io.ReadCloser, error) {
+	resp, err := upstreamClient.Get(u.url)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch data")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("unexpected upstream status %d", resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+// fileUpstream reads the data from a single file on disk.
+type fileUpstream struct {
+	path string
+}
+
+func (u *fileUpstream) Fetch() (io.ReadCloser, error) {
+	return os.Open(u.path)
+}
+
+// directoryUpstream always reads the most recently published
+// snapshot in a directory. New snapshots can be added while
+// the syncer is running.
+type directoryUpstream struct {
+	dir string
+}
+
+func (u *directoryUpstream) Fetch() (io.ReadCloser, error) {
+	files, err := snapshots(u.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, errors.Errorf("upstream: no snapshots found in %s", u.dir)
+	}
+
+	return os.Open(files[len(files)-1])
+}
+
+// replayUpstream feeds a list of snapshots to the syncer, one
+// per Fetch. Once all snapshots have been read, it returns
+// errReplayFinished.
+type replayUpstream struct {
+	mu    sync.Mutex
+	files []string
+	next  int
+}
+
+func (u *replayUpstream) Fetch() (io.ReadCloser, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.next >= len(u.files) {
+		return nil, errReplayFinished
+	}
+
+	f, err := os.Open(u.files[u.next])
+	u.next++
+
+	return f, err
+}
+
+func (u *replayUpstream) remaining() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return len(u.files) - u.next
+}
+
+// snapshots returns all json files in dir, ordered by the time
+// they were published by the DWD. Files whose last_update can't
+// be read are ordered by their modification time instead.
+func snapshots(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type snapshot struct {
+		path      string
+		published time.Time
+	}
+
+	var ss []snapshot
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != ".json" {
+			continue
+		}
+
+		path := filepath.Join(dir, info.Name())
+		published, err := snapshotTime(path)
+		if err != nil {
+			published = info.ModTime()
+		}
+
+		ss = append(ss, snapshot{path, published})
+	}
+
+	sort.SliceStable(ss, func(i, j int) bool {
+		return ss[i].published.Before(ss[j].published)
+	})
+
+	files := make([]string, len(ss))
+	for i, s := range ss {
+		files[i] = s.path
+	}
+
+	return files, nil
+}
+
+func snapshotTime(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	var data struct {
+		LastUpdate string `json:"last_update"`
+	}
+	if err := json.NewDecoder(f).Decode(&data); err != nil {
+		return time.Time{}, err
+	}
+
+	return parseDWDTime(data.LastUpdate)
+}
+
+// parseDWDTime parses timestamps like "2020-01-01 11:00 Uhr"
+// as they are used by the DWD. They are in German local time.
+func parseDWDTime(s string) (time.Time, error) {
+	return time.ParseInLocation(dwdTimeLayout, strings.TrimSpace(s), dwdLocation)
+}
+
+func loadDWDLocation() *time.Location {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		// Not every system ships with timezone data. Being off
+		// by an hour during summer time is good enough for us.
+		return time.FixedZone("CET", 60*60)
+	}
+	return loc
+}
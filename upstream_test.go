@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeSnapshots(t *testing.T, snapshots map[string]string) string {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, lastUpdate := range snapshots {
+		snapshot := *upstreamResponse
+		snapshot.LastUpdate = lastUpdate
+		data, _ := json.Marshal(&snapshot)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestSnapshotsAreOrderedByLastUpdate(t *testing.T) {
+	dir := writeSnapshots(t, map[string]string{
+		"a.json":    "2020-03-02 11:00 Uhr",
+		"b.json":    "2020-03-01 11:00 Uhr",
+		"c.json":    "2020-03-01 09:00 Uhr",
+		"notes.txt": "2020-01-01 11:00 Uhr",
+	})
+	defer os.RemoveAll(dir)
+
+	got, err := snapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(dir, "c.json"),
+		filepath.Join(dir, "b.json"),
+		filepath.Join(dir, "a.json"),
+	}
+
+	if !cmp.Equal(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestReplayUpstream(t *testing.T) {
+	dir := writeSnapshots(t, map[string]string{
+		"first.json":  "2020-03-01 11:00 Uhr",
+		"second.json": "2020-03-02 11:00 Uhr",
+	})
+	defer os.RemoveAll(dir)
+
+	u, err := newUpstream(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syncer := NewUpstreamSyncer(&inMemoryStorage{}, u, 0)
+	syncer.minLocations = 0
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, syncer.Sync(triggerManual).LastUpdate)
+	}
+
+	want := []string{"2020-03-01 11:00 Uhr", "2020-03-02 11:00 Uhr", ""}
+	if !cmp.Equal(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if runs := syncer.Runs(); runs[0].Error != errReplayFinished.Error() {
+		t.Errorf("expected replay to be finished, got %+v", runs[0])
+	}
+}
+
+func TestDirectoryUpstreamUsesLatestSnapshot(t *testing.T) {
+	dir := writeSnapshots(t, map[string]string{
+		"old.json": "2020-03-01 11:00 Uhr",
+		"new.json": "2020-03-02 11:00 Uhr",
+	})
+	defer os.RemoveAll(dir)
+
+	u, err := newUpstream(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syncer := NewUpstreamSyncer(&inMemoryStorage{}, u, 0)
+	syncer.minLocations = 0
+
+	run := syncer.Sync(triggerManual)
+	if run.Error != "" || run.LastUpdate != "2020-03-02 11:00 Uhr" {
+		t.Errorf("expected latest snapshot to be used, got %+v", run)
+	}
+}
+
+func TestSyncerStopsAfterReplay(t *testing.T) {
+	dir := writeSnapshots(t, map[string]string{
+		"first.json":  "2020-03-01 11:00 Uhr",
+		"second.json": "2020-03-02 11:00 Uhr",
+	})
+	defer os.RemoveAll(dir)
+
+	u, err := newUpstream(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syncer := NewUpstreamSyncer(&inMemoryStorage{}, u, time.Millisecond)
+	syncer.minLocations = 0
+
+	done := make(chan struct{})
+	go func() {
+		syncer.Run()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the syncer to stop after the last snapshot")
+	}
+
+	for _, run := range syncer.Runs() {
+		if run.Error != "" {
+			t.Errorf("expected no failed runs, got %+v", run)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// expectedLocations is the number of regions and subregions
+// the DWD publishes forecasts for.
+const expectedLocations = 27
+
+// ValidationError is returned if the upstream data doesn't look
+// like what we expect. None of the data gets saved in this case.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("upstream data failed validation with %d problem(s)", len(e.Problems))
+}
+
+// validateResponse checks the upstream data for anything that
+// would leave us with broken or incomplete reports. minLocations
+// is the minimum number of locations we expect. If it is zero,
+// the number of locations doesn't get checked.
+func validateResponse(r *openDataPollenResponse, minLocations int) error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// Reports are dated by the time of the last update, so
+	// without it we wouldn't know which days they refer to.
+	if _, err := parseDWDTime(r.LastUpdate); err != nil {
+		addProblem("invalid last_update %q", r.LastUpdate)
+	}
+
+	if len(r.Content) == 0 {
+		addProblem("response contains no locations")
+	} else if len(r.Content) < minLocations {
+		addProblem("expected at least %d locations, got %d", minLocations, len(r.Content))
+	}
+
+	seen := make(map[string]bool)
+	for i, lr := range r.Content {
+		if lr == nil {
+			addProblem("location %d is empty", i)
+			continue
+		}
+
+		name := strings.TrimSpace(lr.RegionName)
+		if name == "" {
+			addProblem("location %d has no region name", i)
+			continue
+		}
+		if sub := strings.TrimSpace(lr.PartregionName); sub != "" {
+			name += "/" + sub
+		}
+
+		if seen[name] {
+			addProblem("%s: location appears more than once", name)
+		}
+		seen[name] = true
+
+		if lr.Pollen == nil {
+			addProblem("%s: no pollen data", name)
+			continue
+		}
+
+		// Species we don't know about yet are fine, but all the
+		// species we know about have to be present.
+		for _, sp := range speciesRegistry {
+			if _, ok := lr.Pollen[sp.Key]; !ok {
+				addProblem("%s: no data for %s", name, sp.Name)
+			}
+		}
+
+		for _, key := range sortedSpeciesKeys(lr.Pollen) {
+			report := lr.Pollen[key]
+			if report == nil {
+				addProblem("%s: no data for %s", name, lookupSpecies(key).Name)
+				continue
+			}
+
+			days := []struct {
+				name     string
+				severity string
+			}{
+				{"today", report.Today},
+				{"tomorrow", report.Tomorrow},
+				{"day after tomorrow", report.DayAfterTomorrow},
+			}
+			for _, d := range days {
+				if _, ok := severityMap[d.severity]; !ok {
+					addProblem("%s: unknown severity %q for %s %s", name, d.severity, lookupSpecies(key).Name, d.name)
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{problems}
+	}
+
+	return nil
+}
+
+// quarantine writes a rejected payload to dir so it can be
+// inspected later. It returns the path of the written file.
+func quarantine(dir string, payload []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "unable to create quarantine directory")
+	}
+
+	name := fmt.Sprintf("s31fg-%s.json", time.Now().UTC().Format("20060102T150405.000000000"))
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, payload, 0644); err != nil {
+		return "", errors.Wrap(err, "unable to write quarantined payload")
+	}
+
+	return path, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func copyUpstreamResponse() *openDataPollenResponse {
+	var r openDataPollenResponse
+	data, _ := json.Marshal(upstreamResponse)
+	json.Unmarshal(data, &r)
+	return &r
+}
+
+func TestValidateResponse(t *testing.T) {
+	tests := []struct {
+		description  string
+		modify       func(r *openDataPollenResponse)
+		minLocations int
+		problems     int
+	}{
+		{
+			"valid response",
+			func(r *openDataPollenResponse) {},
+			1,
+			0,
+		},
+		{
+			"no locations",
+			func(r *openDataPollenResponse) { r.Content = nil },
+			0,
+			1,
+		},
+		{
+			"too few locations",
+			func(r *openDataPollenResponse) {},
+			27,
+			1,
+		},
+		{
+			"missing species",
+			func(r *openDataPollenResponse) { delete(r.Content[0].Pollen, "Birke") },
+			0,
+			1,
+		},
+		{
+			"empty species",
+			func(r *openDataPollenResponse) { r.Content[0].Pollen["Birke"] = nil },
+			0,
+			1,
+		},
+		{
+			"unknown species are fine",
+			func(r *openDataPollenResponse) {
+				r.Content[0].Pollen["Ulme"] = &openDataSinglePollenReport{"0", "0", "0"}
+			},
+			0,
+			0,
+		},
+		{
+			"missing pollen",
+			func(r *openDataPollenResponse) { r.Content[0].Pollen = nil },
+			0,
+			1,
+		},
+		{
+			"unknown severity",
+			func(r *openDataPollenResponse) {
+				r.Content[0].Pollen["Hasel"].Today = "4"
+				r.Content[0].Pollen["Hasel"].DayAfterTomorrow = ""
+			},
+			0,
+			2,
+		},
+		{
+			"invalid last update",
+			func(r *openDataPollenResponse) { r.LastUpdate = "yesterday" },
+			0,
+			1,
+		},
+		{
+			"missing last update",
+			func(r *openDataPollenResponse) { r.LastUpdate = "" },
+			0,
+			1,
+		},
+		{
+			"missing region name",
+			func(r *openDataPollenResponse) { r.Content[0].RegionName = " " },
+			0,
+			1,
+		},
+		{
+			"duplicate location",
+			func(r *openDataPollenResponse) { r.Content = append(r.Content, r.Content[0]) },
+			0,
+			1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			r := copyUpstreamResponse()
+			tc.modify(r)
+
+			err := validateResponse(r, tc.minLocations)
+			if tc.problems == 0 {
+				if err != nil {
+					t.Errorf("expected no error, got %q", err)
+				}
+				return
+			}
+
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("expected validation error, got %v", err)
+			}
+
+			if len(verr.Problems) != tc.problems {
+				t.Errorf("expected %d problems, got %q", tc.problems, verr.Problems)
+			}
+		})
+	}
+}
+
+func TestInvalidPayloadIsQuarantined(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := &inMemoryStorage{}
+	syncer := &Syncer{
+		storage:       storage,
+		quarantineDir: dir,
+	}
+
+	r := copyUpstreamResponse()
+	delete(r.Content[0].Pollen, "Erle")
+	payload, _ := json.Marshal(r)
+
+	run := syncer.Import(bytes.NewReader(payload))
+	if run.Error == "" || len(run.Problems) != 1 {
+		t.Fatalf("expected run to fail validation, got %+v", run)
+	}
+
+	if len(storage.data) != 0 {
+		t.Errorf("expected no reports to be saved, got %d", len(storage.data))
+	}
+
+	got, err := ioutil.ReadFile(run.Quarantine)
+	if err != nil {
+		t.Fatalf("expected payload to be quarantined: %q", err)
+	}
+
+	if !bytes.Equal(got, payload) {
+		t.Errorf("quarantined payload doesn't match rejected payload")
+	}
+}
+
+func TestUndecodablePayloadIsRejected(t *testing.T) {
+	storage := &inMemoryStorage{}
+	syncer := &Syncer{storage: storage}
+
+	run := syncer.Import(bytes.NewReader([]byte(`{"content": "nope"}`)))
+	if run.Error == "" {
+		t.Error("expected run to fail")
+	}
+
+	if len(storage.data) != 0 {
+		t.Errorf("expected no reports to be saved, got %d", len(storage.data))
+	}
+}
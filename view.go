@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+const viewByDay = "by_day"
+
+// reportView describes how reports should be presented to
+// the client. By default, reports are returned as they are
+// stored.
+type reportView struct {
+	// day limits the report to a single day if set.
+	day string
+	// byDay groups the report by day and species.
+	byDay bool
+}
+
+// byDayReport is an alternate representation of a PollenReport
+// which is grouped by day first and species second.
+type byDayReport struct {
+	Source     string                `json:"source"`
+	Region     string                `json:"region"`
+	SubRegion  string                `json:"sub_region"`
+	LastUpdate time.Time             `json:"last_update"`
+	Days       map[string]*dayReport `json:"days"`
+}
+
+type dayReport struct {
+	Date   string                `json:"date"`
+	Pollen map[string]*dayPollen `json:"pollen"`
+}
+
+type dayPollen struct {
+	Name        string `json:"name"`
+	LatinName   string `json:"latin_name"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+}
+
+// parseReportView reads the view and day query parameters. If
+// they are invalid, it returns a message explaining why.
+func parseReportView(r *http.Request) (*reportView, string) {
+	q := r.URL.Query()
+	v := &reportView{}
+
+	switch q.Get("view") {
+	case "":
+	case viewByDay:
+		v.byDay = true
+	default:
+		return nil, "view has to be by_day"
+	}
+
+	switch day := q.Get("day"); day {
+	case "":
+	case dayToday, dayTomorrow, dayDayAfterTomorrow:
+		v.day = day
+	default:
+		return nil, "day has to be one of today, tomorrow, day_after_tomorrow"
+	}
+
+	return v, ""
+}
+
+// filter returns copies of the reports which only contain the
+// requested day. The reports are returned as is, if no day
+// was requested.
+func (v *reportView) filter(rs []*PollenReport) []*PollenReport {
+	if v.day == "" {
+		return rs
+	}
+
+	filtered := make([]*PollenReport, len(rs))
+	for i, r := range rs {
+		c := *r
+		c.Pollen = make([]*pollen, len(r.Pollen))
+		for j, p := range r.Pollen {
+			cp := &pollen{Name: p.Name, Slug: p.Slug, LatinName: p.LatinName}
+			switch v.day {
+			case dayToday:
+				cp.Today = p.Today
+			case dayTomorrow:
+				cp.Tomorrow = p.Tomorrow
+			case dayDayAfterTomorrow:
+				cp.DayAfterTomorrow = p.DayAfterTomorrow
+			}
+			c.Pollen[j] = cp
+		}
+		filtered[i] = &c
+	}
+
+	return filtered
+}
+
+// render returns the JSON representation of a single report.
+// The report should already be filtered.
+func (v *reportView) render(r *PollenReport) interface{} {
+	if !v.byDay {
+		return r
+	}
+
+	br := &byDayReport{
+		Source:     r.Source,
+		Region:     r.Region,
+		SubRegion:  r.SubRegion,
+		LastUpdate: r.LastUpdate,
+		Days:       make(map[string]*dayReport),
+	}
+
+	for _, p := range r.Pollen {
+		for _, d := range p.days() {
+			if d.report == nil {
+				continue
+			}
+
+			day, ok := br.Days[d.name]
+			if !ok {
+				day = &dayReport{
+					Date:   r.Date().AddDate(0, 0, dayOffset(d.name)).Format("2006-01-02"),
+					Pollen: make(map[string]*dayPollen),
+				}
+				br.Days[d.name] = day
+			}
+
+			day.Pollen[p.Slug] = &dayPollen{
+				Name:        p.Name,
+				LatinName:   p.LatinName,
+				Severity:    d.report.Severity,
+				Description: d.report.Description,
+			}
+		}
+	}
+
+	return br
+}
+
+// export returns the reports in a form which can be rendered in
+// every format the view supports. The reports should already be
+// filtered.
+func (v *reportView) export(rs []*PollenReport) exportable {
+	if !v.byDay {
+		return reportList(rs)
+	}
+	return byDayList{reportList(rs), v}
+}
+
+// renderAll returns the JSON representation of a list of
+// reports. The reports should already be filtered.
+func (v *reportView) renderAll(rs []*PollenReport) interface{} {
+	if !v.byDay {
+		return rs
+	}
+
+	views := make([]interface{}, len(rs))
+	for i, r := range rs {
+		views[i] = v.render(r)
+	}
+
+	return views
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReportViews(t *testing.T) {
+	report := &PollenReport{
+		Region:     "region-a",
+		SubRegion:  "subregion-aa",
+		LastUpdate: time.Date(2020, 3, 31, 11, 0, 0, 0, dwdLocation),
+		Pollen: []*pollen{
+			{
+				Name:             "Birke",
+				Slug:             "birke",
+				LatinName:        "Betula",
+				Today:            &pollenDayReport{"2", "mittlere Belastung"},
+				Tomorrow:         &pollenDayReport{"1", "geringe Belastung"},
+				DayAfterTomorrow: &pollenDayReport{"0", "keine Belastung"},
+			},
+		},
+	}
+
+	t.Run("day filter", func(t *testing.T) {
+		v := &reportView{day: dayTomorrow}
+		got := v.filter([]*PollenReport{report})[0]
+
+		want := &pollen{
+			Name:      "Birke",
+			Slug:      "birke",
+			LatinName: "Betula",
+			Tomorrow:  &pollenDayReport{"1", "geringe Belastung"},
+		}
+		if diff := cmp.Diff(got.Pollen[0], want); diff != "" {
+			t.Error(diff)
+		}
+
+		if report.Pollen[0].Today == nil {
+			t.Error("filtering must not modify the original report")
+		}
+	})
+
+	t.Run("by day", func(t *testing.T) {
+		v := &reportView{byDay: true, day: dayDayAfterTomorrow}
+		got := v.render(v.filter([]*PollenReport{report})[0])
+
+		want := &byDayReport{
+			Region:     "region-a",
+			SubRegion:  "subregion-aa",
+			LastUpdate: report.LastUpdate,
+			Days: map[string]*dayReport{
+				dayDayAfterTomorrow: {
+					Date: "2020-04-02",
+					Pollen: map[string]*dayPollen{
+						"birke": {"Birke", "Betula", "0", "keine Belastung"},
+					},
+				},
+			},
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Error(diff)
+		}
+	})
+}
+
+func TestReportViewParameters(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+
+	s := createServer()
+	s.storage = newStorage(mr)
+
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"/pollen?view=by_day", http.StatusOK},
+		{"/pollen/region/region-a?day=today", http.StatusOK},
+		{"/pollen/subregion/subregion_aa?view=by_day&day=today", http.StatusOK},
+		{"/pollen?view=by_species", http.StatusBadRequest},
+		{"/pollen?day=yesterday", http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+
+			if w.Code != tc.want {
+				t.Errorf("wanted status %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/pollen/subregion/subregion_aa?view=by_day&day=today", nil))
+
+	var got byDayReport
+	json.NewDecoder(w.Body).Decode(&got)
+	if got.Days[dayToday] == nil || got.Days[dayToday].Pollen["roggen"].Severity != "2" {
+		t.Errorf("unexpected response %+v", got)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxDeliveries is the number of delivery attempts we keep
+	// around per subscription.
+	maxDeliveries = 50
+
+	webhookEventThresholdCrossed = "threshold_crossed"
+	webhookSignatureHeader       = "X-Achoo-Signature"
+	webhookEventHeader           = "X-Achoo-Event"
+)
+
+// errPrivateAddress is returned if a webhook points to an address
+// which isn't reachable from the public internet.
+var errPrivateAddress = errors.New("webhook: address is not public")
+
+// blockedNetworks are the address ranges webhooks may not point to.
+// Otherwise subscriptions could be used to reach services which are
+// only meant to be available from within our own network.
+var blockedNetworks = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// Subscription is a webhook which gets called whenever the
+// forecast for its subregion reaches the minimum severity for
+// one of its species.
+type Subscription struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Subregion   string    `json:"subregion"`
+	Species     []string  `json:"species"`
+	MinSeverity string    `json:"min_severity"`
+	Secret      string    `json:"secret,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Delivery is the log entry of a single attempt to call
+// a webhook.
+type Delivery struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscription_id"`
+	Attempt        int       `json:"attempt"`
+	StatusCode     int       `json:"status_code,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Success        bool      `json:"success"`
+	DurationMS     int64     `json:"duration_ms"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// SubscriptionStorage defines a type that can save and retrieve
+// webhook subscriptions and their delivery logs.
+type SubscriptionStorage interface {
+	SaveSubscription(s *Subscription) error
+	GetSubscription(id string) (*Subscription, error)
+	DeleteSubscription(id string) error
+	SubscriptionsForSubregion(subregion string) ([]*Subscription, error)
+	LogDelivery(d *Delivery) error
+	Deliveries(subscriptionID string) ([]*Delivery, error)
+}
+
+// webhookAlert describes a species which crossed the threshold
+// of a subscription on a given day.
+type webhookAlert struct {
+	Species     string `json:"species"`
+	Name        string `json:"name"`
+	Day         string `json:"day"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+}
+
+type webhookPayload struct {
+	Event          string          `json:"event"`
+	SubscriptionID string          `json:"subscription_id"`
+	Alerts         []*webhookAlert `json:"alerts"`
+	Report         *PollenReport   `json:"report"`
+}
+
+// WebhookNotifier calls the webhooks of all subscriptions whose
+// threshold was crossed by a sync.
+type WebhookNotifier struct {
+	storage SubscriptionStorage
+	client  *http.Client
+
+	// attempts is the number of times a delivery is attempted
+	// before giving up. backoff is the delay before the first
+	// retry, it doubles with every further attempt.
+	attempts int
+	backoff  time.Duration
+
+	wg sync.WaitGroup
+}
+
+// NewWebhookNotifier returns a notifier which looks up the
+// subscriptions in the provided storage.
+func NewWebhookNotifier(s SubscriptionStorage) *WebhookNotifier {
+	return &WebhookNotifier{
+		storage:  s,
+		client:   newWebhookClient(),
+		attempts: 3,
+		backoff:  2 * time.Second,
+	}
+}
+
+// ReportsSynced implements SyncObserver. Webhooks get called in
+// the background so a slow receiver can't hold up the sync.
+func (n *WebhookNotifier) ReportsSynced(updates []*ReportUpdate) {
+	for _, u := range updates {
+		subs, err := n.storage.SubscriptionsForSubregion(u.Current.Key())
+		if err != nil {
+			log.Printf("[webhook] unable to load subscriptions for %q: %q", u.Current.Key(), err.Error())
+			continue
+		}
+
+		for _, sub := range subs {
+			alerts := sub.alerts(u)
+			if len(alerts) == 0 {
+				continue
+			}
+
+			payload := &webhookPayload{
+				Event:          webhookEventThresholdCrossed,
+				SubscriptionID: sub.ID,
+				Alerts:         alerts,
+				Report:         u.Current,
+			}
+
+			n.wg.Add(1)
+			go func(sub *Subscription) {
+				defer n.wg.Done()
+				n.deliver(sub, payload)
+			}(sub)
+		}
+	}
+}
+
+// Wait blocks until all pending deliveries are done.
+func (n *WebhookNotifier) Wait() {
+	n.wg.Wait()
+}
+
+func (n *WebhookNotifier) deliver(sub *Subscription, payload *webhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("[webhook] unable to marshal payload: %q", err.Error())
+		return
+	}
+
+	backoff := n.backoff
+	for attempt := 1; attempt <= n.attempts; attempt++ {
+		d := n.send(sub, body)
+		d.Attempt = attempt
+
+		if err := n.storage.LogDelivery(d); err != nil {
+			log.Printf("[webhook] unable to log delivery: %q", err.Error())
+		}
+
+		if d.Success {
+			return
+		}
+
+		log.Printf("[webhook] delivery %d to %s failed: %s", attempt, sub.URL, d.Error)
+		if attempt < n.attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (n *WebhookNotifier) send(sub *Subscription, body []byte) *Delivery {
+	d := &Delivery{
+		ID:             randomToken(8),
+		SubscriptionID: sub.ID,
+		CreatedAt:      time.Now(),
+	}
+	defer func() {
+		d.DurationMS = int64(time.Since(d.CreatedAt) / time.Millisecond)
+	}()
+
+	req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(body))
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, webhookEventThresholdCrossed)
+	req.Header.Set(webhookSignatureHeader, "sha256="+sign(sub.Secret, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	resp.Body.Close()
+
+	d.StatusCode = resp.StatusCode
+	d.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !d.Success {
+		d.Error = errors.Errorf("unexpected status %d", resp.StatusCode).Error()
+	}
+
+	return d
+}
+
+// alerts returns all species and days of the update which reached
+// the subscription's minimum severity but didn't for the same date
+// in the previous report. That way a webhook only gets called once
+// per crossing instead of on every sync.
+func (sub *Subscription) alerts(u *ReportUpdate) []*webhookAlert {
+	min, ok := severityLevel(sub.MinSeverity)
+	if !ok {
+		return nil
+	}
+
+	var alerts []*webhookAlert
+	for _, p := range u.Current.Pollen {
+		if !sub.includesSpecies(p.Slug) {
+			continue
+		}
+
+		for _, day := range p.days() {
+			if day.report == nil {
+				continue
+			}
+
+			level, ok := severityLevel(day.report.Severity)
+			if !ok || level < min {
+				continue
+			}
+
+			// The previous report might have been issued on another
+			// day, so days get compared by date rather than by name.
+			date := u.Current.Date().AddDate(0, 0, dayOffset(day.name))
+			if prevDay := u.Previous.forecastOn(p.Slug, date); prevDay != nil {
+				if prevLevel, ok := severityLevel(prevDay.Severity); ok && prevLevel >= min {
+					continue
+				}
+			}
+
+			alerts = append(alerts, &webhookAlert{
+				Species:     p.Slug,
+				Name:        p.Name,
+				Day:         day.name,
+				Severity:    day.report.Severity,
+				Description: day.report.Description,
+			})
+		}
+	}
+
+	return alerts
+}
+
+func (sub *Subscription) includesSpecies(slug string) bool {
+	if len(sub.Species) == 0 {
+		return true
+	}
+
+	for _, s := range sub.Species {
+		if s == slug {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newWebhookClient returns a client which refuses to connect to
+// private addresses. The address gets checked right before
+// connecting, so a host can't pass the check when subscribing and
+// resolve to somewhere else later on. This covers redirects, too.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicIP(net.ParseIP(host)) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// checkWebhookHost resolves the host and makes sure all of its
+// addresses are public.
+func checkWebhookHost(host string) error {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return errPrivateAddress
+		}
+	}
+
+	return nil
+}
+
+// isPublicIP checks that the address isn't part of any of the
+// blocked networks. IPv4 addresses mapped to IPv6 are checked
+// as IPv4 addresses.
+func isPublicIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// sign returns the hex encoded HMAC-SHA256 of body. Receivers
+// use it to verify that a webhook was sent by us.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// randomToken returns a random hex string of n bytes.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
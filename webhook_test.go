@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func reportWithSeverities(subregion string, severities map[string]string) *PollenReport {
+	r := &PollenReport{Region: "region-a", SubRegion: subregion}
+	for slug, severity := range severities {
+		r.Pollen = append(r.Pollen, &pollen{
+			Name:             slug,
+			Slug:             slug,
+			Today:            &pollenDayReport{severity, severityMap[severity]},
+			Tomorrow:         &pollenDayReport{"0", severityMap["0"]},
+			DayAfterTomorrow: &pollenDayReport{"0", severityMap["0"]},
+		})
+	}
+	return r
+}
+
+// birkeForecast returns a report issued on the provided day of
+// March 2020 with the severities for today and tomorrow.
+func birkeForecast(day int, today, tomorrow string) *PollenReport {
+	r := reportWithSeverities("subregion-aa", map[string]string{"birke": today})
+	r.LastUpdate = time.Date(2020, 3, day, 11, 0, 0, 0, dwdLocation)
+	r.Pollen[0].Tomorrow = &pollenDayReport{tomorrow, severityMap[tomorrow]}
+	return r
+}
+
+func TestSubscriptionAlerts(t *testing.T) {
+	tests := []struct {
+		description string
+		species     []string
+		previous    *PollenReport
+		current     *PollenReport
+		want        int
+	}{
+		{
+			"no previous report",
+			nil,
+			nil,
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "2"}),
+			1,
+		},
+		{
+			"below threshold",
+			nil,
+			nil,
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "1-2"}),
+			0,
+		},
+		{
+			"crossed threshold",
+			nil,
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "1"}),
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "2-3"}),
+			1,
+		},
+		{
+			"already above threshold",
+			nil,
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "2"}),
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "3"}),
+			0,
+		},
+		{
+			"already above threshold on the previous day",
+			nil,
+			birkeForecast(1, "0", "2"),
+			birkeForecast(2, "2", "0"),
+			0,
+		},
+		{
+			"same day name on another date",
+			nil,
+			birkeForecast(1, "0", "2"),
+			birkeForecast(2, "0", "2"),
+			1,
+		},
+		{
+			"species not subscribed",
+			[]string{"hasel"},
+			nil,
+			reportWithSeverities("subregion-aa", map[string]string{"birke": "3", "hasel": "1"}),
+			0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			sub := &Subscription{Species: tc.species, MinSeverity: "2"}
+
+			got := sub.alerts(&ReportUpdate{tc.previous, tc.current})
+			if len(got) != tc.want {
+				t.Errorf("wanted %d alerts, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSubscriptionStorage(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	s := newStorage(mr)
+
+	sub := &Subscription{ID: "::id::", Subregion: "subregion-aa", MinSeverity: "2"}
+	if err := s.SaveSubscription(sub); err != nil {
+		t.Fatalf("unable to save subscription: %q", err)
+	}
+
+	subs, err := s.SubscriptionsForSubregion("subregion_aa")
+	if err != nil || len(subs) != 1 || subs[0].ID != "::id::" {
+		t.Errorf("expected subscription for subregion, got %+v (%v)", subs, err)
+	}
+
+	if err := s.DeleteSubscription("::id::"); err != nil {
+		t.Fatalf("unable to delete subscription: %q", err)
+	}
+
+	if _, err := s.GetSubscription("::id::"); err != ErrNotFound {
+		t.Errorf("expected subscription to be deleted, got %v", err)
+	}
+
+	subs, _ = s.SubscriptionsForSubregion("subregion_aa")
+	if len(subs) != 0 {
+		t.Errorf("expected no subscriptions left, got %+v", subs)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	s := newStorage(mr)
+
+	var calls int32
+	var body []byte
+	var signature string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first attempt so the delivery gets retried.
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(webhookSignatureHeader)
+	}))
+	defer receiver.Close()
+
+	sub := &Subscription{
+		ID:          "::id::",
+		URL:         receiver.URL,
+		Subregion:   "subregion_aa",
+		MinSeverity: "2",
+		Secret:      "::secret::",
+	}
+	s.SaveSubscription(sub)
+
+	n := NewWebhookNotifier(s)
+	n.backoff = time.Millisecond
+	// The receiver listens on a loopback address, which webhooks
+	// can't reach otherwise.
+	n.client = receiver.Client()
+	n.ReportsSynced([]*ReportUpdate{
+		{nil, reportWithSeverities("subregion-aa", map[string]string{"birke": "3"})},
+		{nil, reportWithSeverities("subregion-ab", map[string]string{"birke": "3"})},
+	})
+	n.Wait()
+
+	if calls != 2 {
+		t.Errorf("expected webhook to be called twice, got %d", calls)
+	}
+
+	if signature != "sha256="+sign("::secret::", body) {
+		t.Errorf("invalid signature %q", signature)
+	}
+
+	var payload webhookPayload
+	json.Unmarshal(body, &payload)
+	if payload.SubscriptionID != "::id::" || len(payload.Alerts) != 1 || payload.Alerts[0].Species != "birke" {
+		t.Errorf("unexpected payload %s", body)
+	}
+
+	ds, _ := s.Deliveries("::id::")
+	if len(ds) != 2 || !ds[0].Success || ds[1].Success || ds[0].Attempt != 2 {
+		t.Errorf("expected a failed and a successful delivery, got %+v", ds)
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	s := newStorage(mr)
+
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer receiver.Close()
+
+	sub := &Subscription{ID: "::id::", URL: receiver.URL, Subregion: "subregion_aa", MinSeverity: "2"}
+	s.SaveSubscription(sub)
+
+	n := NewWebhookNotifier(s)
+	n.attempts = 1
+	n.ReportsSynced([]*ReportUpdate{
+		{nil, reportWithSeverities("subregion-aa", map[string]string{"birke": "3"})},
+	})
+	n.Wait()
+
+	if calls != 0 {
+		t.Errorf("expected loopback receiver not to be called, got %d calls", calls)
+	}
+
+	ds, _ := s.Deliveries("::id::")
+	if len(ds) != 1 || ds[0].Success || ds[0].Error == "" {
+		t.Errorf("expected a failed delivery, got %+v", ds)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := map[string]bool{
+		"203.0.113.10":     true,
+		"2001:db8::1":      true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	}
+
+	for ip, want := range tests {
+		if got := isPublicIP(net.ParseIP(ip)); got != want {
+			t.Errorf("isPublicIP(%s): expected %v, got %v", ip, want, got)
+		}
+	}
+}
+
+func TestCreateSubscription(t *testing.T) {
+	mr := newMiniRedisServer()
+	defer mr.Close()
+	storage := newStorage(mr)
+
+	srv := createServer()
+	srv.storage = storage
+	srv.subscriptions = storage
+
+	tests := []struct {
+		description string
+		body        string
+		want        int
+	}{
+		{
+			"valid subscription",
+			`{"url":"https://203.0.113.10/hook","subregion":"subregion-aa","species":["Birke"],"min_severity":"2"}`,
+			http.StatusCreated,
+		},
+		{
+			"unknown subregion",
+			`{"url":"https://203.0.113.10/hook","subregion":"nope","min_severity":"2"}`,
+			http.StatusBadRequest,
+		},
+		{
+			"unknown species",
+			`{"url":"https://203.0.113.10/hook","subregion":"subregion-aa","species":["kaktus"],"min_severity":"2"}`,
+			http.StatusBadRequest,
+		},
+		{
+			"invalid severity",
+			`{"url":"https://203.0.113.10/hook","subregion":"subregion-aa","min_severity":"5"}`,
+			http.StatusBadRequest,
+		},
+		{
+			"loopback url",
+			`{"url":"http://localhost:8000/hook","subregion":"subregion-aa","min_severity":"2"}`,
+			http.StatusBadRequest,
+		},
+		{
+			"metadata url",
+			`{"url":"http://169.254.169.254/latest/meta-data","subregion":"subregion-aa","min_severity":"2"}`,
+			http.StatusBadRequest,
+		},
+		{
+			"private url",
+			`{"url":"https://[fd00::1]/hook","subregion":"subregion-aa","min_severity":"2"}`,
+			http.StatusBadRequest,
+		},
+		{
+			"invalid url",
+			`{"url":"example.com/hook","subregion":"subregion-aa","min_severity":"2"}`,
+			http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, httptest.NewRequest("POST", "/subscriptions", bytes.NewBufferString(tc.body)))
+
+			if w.Code != tc.want {
+				t.Errorf("wanted status %d, got %d: %s", tc.want, w.Code, w.Body)
+			}
+
+			if w.Code != http.StatusCreated {
+				return
+			}
+
+			var sub Subscription
+			json.NewDecoder(w.Body).Decode(&sub)
+			if sub.Secret == "" || sub.Subregion != "subregion_aa" || sub.Species[0] != "birke" {
+				t.Errorf("unexpected subscription %+v", sub)
+			}
+
+			saved, err := storage.GetSubscription(sub.ID)
+			if err != nil || saved.URL != "https://203.0.113.10/hook" {
+				t.Errorf("expected subscription to be saved, got %+v (%v)", saved, err)
+			}
+		})
+	}
+}